@@ -0,0 +1,461 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+const version string = "1.0.0"
+
+// pathList is a flag.Value to specify paths multiple times.
+type pathList []string
+
+func (p *pathList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+type config struct {
+	verbose        bool
+	forceFloat64   bool
+	signedInt      bool
+	timestampPaths pathList
+	eventTimePaths pathList
+	binPaths       pathList
+	binEnc         string /* encoding of bin which msgpack2json -bin-encoding shows. hex, base64, base64url or tagged */
+	keys           string /* policy of keys which msgpack2json -keys shows. string, json or pairs */
+
+	timestamps []*regexp.Regexp
+	eventTimes []*regexp.Regexp
+	bins       []*regexp.Regexp
+}
+
+// compilePath converts a path like $.a[*].b into regexp.
+// "[*]" matches any array index.
+func compilePath(path string) (*regexp.Regexp, error) {
+	parts := strings.Split(path, "[*]")
+	for i, v := range parts {
+		parts[i] = regexp.QuoteMeta(v)
+	}
+	return regexp.Compile(`^` + strings.Join(parts, `\[[0-9]+\]`) + `$`)
+}
+
+func (cnf *config) compilePaths() error {
+	for _, v := range cnf.timestampPaths {
+		re, err := compilePath(v)
+		if err != nil {
+			return err
+		}
+		cnf.timestamps = append(cnf.timestamps, re)
+	}
+	for _, v := range cnf.eventTimePaths {
+		re, err := compilePath(v)
+		if err != nil {
+			return err
+		}
+		cnf.eventTimes = append(cnf.eventTimes, re)
+	}
+	for _, v := range cnf.binPaths {
+		re, err := compilePath(v)
+		if err != nil {
+			return err
+		}
+		cnf.bins = append(cnf.bins, re)
+	}
+	return nil
+}
+
+func matchPath(list []*regexp.Regexp, path string) bool {
+	for _, v := range list {
+		if v.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func keyPath(path string, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+func newNumber(n json.Number, cnf *config) (*msgpack.MPObject, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			if cnf.signedInt {
+				return msgpack.NewInt(i), nil
+			}
+			return msgpack.NewInteger(i), nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return msgpack.NewUint(u), nil
+		}
+		/* out of range of integer. encode as float. */
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	if cnf.forceFloat64 {
+		return msgpack.NewFloat64(f), nil
+	}
+	return msgpack.NewFloat(f), nil
+}
+
+// decodeBin decodes s which msgpack2json shows by -bin-encoding.
+func decodeBin(s string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		return parseHex(s)
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	case "base64url":
+		return base64.URLEncoding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// newTagged converts the object which msgpack2json -bin-encoding tagged shows into bin or ext.
+// kv is a list of key and value pairs of the object. ok is false if it is not tagged.
+// e.g. {"$bin":"3q2+7w==","encoding":"base64"} and {"$ext":1,"data":"3q2+7w=="}
+func newTagged(kv []*msgpack.MPObject, path string) (obj *msgpack.MPObject, ok bool, err error) {
+	if len(kv) != 4 {
+		return nil, false, nil
+	}
+	m := map[string]*msgpack.MPObject{}
+	for i := 0; i < len(kv); i += 2 {
+		k, _ := kv[i].Str()
+		m[k] = kv[i+1]
+	}
+
+	if v, ok := m["$bin"]; ok && m["encoding"] != nil {
+		s, ok1 := v.Str()
+		enc, ok2 := m["encoding"].Str()
+		if !ok1 || !ok2 {
+			return nil, false, nil
+		}
+		data, err := decodeBin(s, enc)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %s", path, err)
+		}
+		return msgpack.NewBin(data), true, nil
+	}
+	if v, ok := m["$ext"]; ok && m["data"] != nil {
+		t, ok1 := v.Int()
+		s, ok2 := m["data"].Str()
+		if !ok1 || !ok2 || t < -128 || t > 127 {
+			return nil, false, nil
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %s", path, err)
+		}
+		return msgpack.NewExt(int8(t), data), true, nil
+	}
+	return nil, false, nil
+}
+
+// newKeysTagged converts the map which msgpack2json -keys json or pairs shows back into map.
+// kv is a list of key and value pairs of the object. ok is false if it is not tagged.
+// e.g. {"$json_keys":{"1":"a","\"b\"":"c"}} and {"$pairs":[[1,"a"],["b","c"]]}
+func newKeysTagged(kv []*msgpack.MPObject, path string, cnf *config) (obj *msgpack.MPObject, ok bool, err error) {
+	if len(kv) != 2 {
+		return nil, false, nil
+	}
+	tag, _ := kv[0].Str()
+	v := kv[1]
+
+	/* the JSON text of a key has {"$pairs":...} of map which has keys of array or map even with -keys json */
+	if tag == "$pairs" && msgpack.IsArray(v.FirstByte) {
+		children := []*msgpack.MPObject{}
+		for _, pair := range v.Child {
+			if !msgpack.IsArray(pair.FirstByte) || len(pair.Child) != 2 {
+				return nil, false, nil
+			}
+			children = append(children, pair.Child...)
+		}
+		return msgpack.NewMap(children), true, nil
+	}
+	if tag == "$json_keys" && cnf.keys == "json" && msgpack.IsMap(v.FirstByte) {
+		children := []*msgpack.MPObject{}
+		for i := 0; i+1 < len(v.Child); i += 2 {
+			text, _ := v.Child[i].Str()
+			key, err := readKey(text, keyPath(path, tag), cnf)
+			if err != nil {
+				return nil, true, err
+			}
+			children = append(children, key, v.Child[i+1])
+		}
+		return msgpack.NewMap(children), true, nil
+	}
+	return nil, false, nil
+}
+
+// readKey converts the JSON text of a key which msgpack2json -keys json shows.
+func readKey(text string, path string, cnf *config) (*msgpack.MPObject, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err == nil {
+		var key *msgpack.MPObject
+		key, err = readValue(dec, tok, keyPath(path, text), cnf)
+		if err == nil {
+			/* the text must be a value */
+			if _, err = dec.Token(); err == io.EOF {
+				return key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: invalid key %q", path, text)
+}
+
+func newString(s string, path string, cnf *config) (*msgpack.MPObject, error) {
+	if matchPath(cnf.bins, path) {
+		data, err := decodeBin(s, cnf.binEnc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return msgpack.NewBin(data), nil
+	}
+
+	isTimestamp := matchPath(cnf.timestamps, path)
+	isEventTime := matchPath(cnf.eventTimes, path)
+	if !isTimestamp && !isEventTime {
+		return msgpack.NewStr(s), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if isTimestamp {
+		return msgpack.NewTimestamp(t), nil
+	}
+	return msgpack.NewEventTime(t), nil
+}
+
+// nextToken reads a token inside of array or object.
+func nextToken(dec *json.Decoder) (json.Token, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return tok, err
+}
+
+func readValue(dec *json.Decoder, tok json.Token, path string, cnf *config) (*msgpack.MPObject, error) {
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '[':
+			children := []*msgpack.MPObject{}
+			for i := 0; ; i++ {
+				tok, err := nextToken(dec)
+				if err != nil {
+					return nil, err
+				}
+				if tok == json.Delim(']') {
+					break
+				}
+				child, err := readValue(dec, tok, fmt.Sprintf("%s[%d]", path, i), cnf)
+				if err != nil {
+					return nil, err
+				}
+				children = append(children, child)
+			}
+			return msgpack.NewArray(children), nil
+		case '{':
+			kv := []*msgpack.MPObject{}
+			for {
+				tok, err := nextToken(dec)
+				if err != nil {
+					return nil, err
+				}
+				if tok == json.Delim('}') {
+					break
+				}
+				key := tok.(string)
+				tok, err = nextToken(dec)
+				if err != nil {
+					return nil, err
+				}
+				value, err := readValue(dec, tok, keyPath(path, key), cnf)
+				if err != nil {
+					return nil, err
+				}
+				kv = append(kv, msgpack.NewStr(key), value)
+			}
+			if cnf.binEnc == "tagged" {
+				if obj, ok, err := newTagged(kv, path); ok {
+					return obj, err
+				}
+			}
+			if cnf.keys == "json" || cnf.keys == "pairs" {
+				if obj, ok, err := newKeysTagged(kv, path, cnf); ok {
+					return obj, err
+				}
+			}
+			return msgpack.NewMap(kv), nil
+		}
+	case bool:
+		return msgpack.NewBool(v), nil
+	case nil:
+		return msgpack.NewNil(), nil
+	case json.Number:
+		return newNumber(v, cnf)
+	case string:
+		return newString(v, path, cnf)
+	}
+	return nil, fmt.Errorf("%s: unexpected token %v", path, tok)
+}
+
+// convert reads JSON or NDJSON from in and writes MessagePack to out.
+func convert(in io.Reader, out io.Writer, cnf *config) int {
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error(%s) detected. Incoming data may be broken.\n", err)
+			return 1
+		}
+		obj, err := readValue(dec, tok, "$", cnf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error(%s) detected. Incoming data may be broken.\n", err)
+			return 1
+		}
+		err = msgpack.Encode(out, obj)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "msgpack.Encode :%v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// process converts in according to the mode.
+func process(in io.Reader, out io.Writer, cnf *config) int {
+	if cnf.verbose {
+		return reverse(in, out)
+	}
+	return convert(in, out, cnf)
+}
+
+func readStdin(cnf *config) int {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return process(os.Stdin, os.Stdout, cnf)
+	}
+	return 0
+}
+
+func readFiles(files []string, cnf *config) int {
+	ret := 0
+	for _, v := range files {
+		file, err := os.Open(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "os.Open :%v\n", err)
+			ret = 1
+			continue
+		}
+		if process(file, os.Stdout, cnf) != 0 {
+			ret = 1
+		}
+		file.Close()
+	}
+	return ret
+}
+
+func cmdMain() int {
+	showVersion := false
+
+	config := config{}
+
+	flag.BoolVar(&config.verbose, "V", false, "read verbose JSON of msgpack2json and keep the format of each object")
+	flag.BoolVar(&config.forceFloat64, "float64", false, "encode all floating point numbers as float 64")
+	flag.BoolVar(&config.signedInt, "int", false, "encode non-negative integers as int family instead of uint family")
+	flag.Var(&config.timestampPaths, "timestamp", "path of RFC3339 string to encode as timestamp ext (-1). e.g. $.time, $[*][0]")
+	flag.Var(&config.eventTimePaths, "eventtime", "path of RFC3339 string to encode as Fluentd EventTime ext (0). e.g. $[0]")
+	flag.Var(&config.binPaths, "bin", "path of string to decode as bin by -bin-encoding. e.g. $.data")
+	flag.StringVar(&config.binEnc, "bin-encoding", "hex", "encoding of bin which msgpack2json -bin-encoding shows: hex, base64, base64url or tagged")
+	flag.StringVar(&config.keys, "keys", "string", "policy of keys which msgpack2json -keys shows: string, json or pairs")
+	flag.BoolVar(&showVersion, "v", false, "show version")
+
+	flag.Parse()
+
+	if showVersion {
+		fmt.Printf("Ver: %s\n", version)
+		return 0
+	}
+
+	switch config.binEnc {
+	case "hex", "base64", "base64url":
+	case "tagged":
+		if len(config.binPaths) > 0 {
+			fmt.Fprintf(os.Stderr, "-bin can not be used with -bin-encoding tagged\n")
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -bin-encoding value: %s\n", config.binEnc)
+		return 1
+	}
+
+	switch config.keys {
+	case "string", "json", "pairs":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -keys value: %s\n", config.keys)
+		return 1
+	}
+
+	if err := config.compilePaths(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path :%v\n", err)
+		return 1
+	}
+
+	if flag.NArg() > 0 {
+		return readFiles(flag.Args(), &config)
+	}
+	return readStdin(&config)
+}
+
+func main() {
+	os.Exit(cmdMain())
+}
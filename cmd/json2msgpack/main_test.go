@@ -0,0 +1,151 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack/render"
+)
+
+func TestConvert(t *testing.T) {
+	type testcase struct {
+		casename string
+		json     string
+		cnf      config
+		expected []byte
+	}
+
+	cases := []testcase{
+		{"fixmap", `{"compact":true,"schema":0}`, config{}, []byte{0x82, 0xa7, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0xc3, 0xa6, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x00}},
+		{"key order", `{"b":1,"a":2}`, config{}, []byte{0x82, 0xa1, 0x62, 0x01, 0xa1, 0x61, 0x02}},
+		{"fixarray", `[1,-1,null,false]`, config{}, []byte{0x94, 0x01, 0xff, 0xc0, 0xc2}},
+		{"ndjson", "1\n\"AB\"\n[]\n", config{}, []byte{0x01, 0xa2, 0x41, 0x42, 0x90}},
+		{"uint8", `200`, config{}, []byte{0xcc, 0xc8}},
+		{"uint64", `18446744073709551615`, config{}, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"int16", `200`, config{signedInt: true}, []byte{0xd1, 0x00, 0xc8}},
+		{"int8", `-100`, config{}, []byte{0xd0, 0x9c}},
+		{"float32", `1.5`, config{}, []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float64", `0.1`, config{}, []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"force float64", `1.5`, config{forceFloat64: true}, []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"exponent", `1e2`, config{}, []byte{0xca, 0x42, 0xc8, 0x00, 0x00}},
+		{"timestamp", `{"time":"1970-01-01T00:00:01Z"}`, config{timestampPaths: pathList{"$.time"}},
+			[]byte{0x81, 0xa4, 0x74, 0x69, 0x6d, 0x65, 0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{"timestamp other path", `{"time":"1970-01-01T00:00:01Z"}`, config{timestampPaths: pathList{"$.tag"}},
+			append([]byte{0x81, 0xa4, 0x74, 0x69, 0x6d, 0x65, 0xb4}, []byte("1970-01-01T00:00:01Z")...)},
+		{"eventtime", `["tag",["1970-01-01T00:00:01.000000001Z",{}]]`, config{eventTimePaths: pathList{"$[1][*]"}},
+			[]byte{0x92, 0xa3, 0x74, 0x61, 0x67, 0x92, 0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x80}},
+		{"quoted key", `{"a-b":"1970-01-01T00:00:00Z"}`, config{timestampPaths: pathList{`$["a-b"]`}},
+			[]byte{0x81, 0xa3, 0x61, 0x2d, 0x62, 0xd6, 0xff, 0x00, 0x00, 0x00, 0x00}},
+		{"bin hex", `["0xdeadbeef"]`, config{binPaths: pathList{"$[0]"}, binEnc: "hex"}, []byte{0x91, 0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef}},
+		{"bin base64", `{"a":"3q2+7w==","b":"3q2+7w=="}`, config{binPaths: pathList{"$.a"}, binEnc: "base64"},
+			[]byte{0x82, 0xa1, 0x61, 0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef, 0xa1, 0x62, 0xa8, 0x33, 0x71, 0x32, 0x2b, 0x37, 0x77, 0x3d, 0x3d}},
+		{"bin base64url", `"3q2-7w=="`, config{binPaths: pathList{"$"}, binEnc: "base64url"}, []byte{0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef}},
+		{"tagged bin", `[{"$bin":"3q2+7w==","encoding":"base64"},{"encoding":"hex","$bin":"0xff"}]`, config{binEnc: "tagged"},
+			[]byte{0x92, 0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef, 0xc4, 0x01, 0xff}},
+		{"tagged ext", `{"$ext":1,"data":"3q2+7w=="}`, config{binEnc: "tagged"}, []byte{0xd6, 0x01, 0xde, 0xad, 0xbe, 0xef}},
+		{"tagged ext negative", `{"$ext":-2,"data":"/w=="}`, config{binEnc: "tagged"}, []byte{0xd4, 0xfe, 0xff}},
+		{"not tagged", `{"$ext":1,"data":"3q2+7w==","x":0}`, config{binEnc: "tagged"},
+			[]byte{0x83, 0xa4, 0x24, 0x65, 0x78, 0x74, 0x01, 0xa4, 0x64, 0x61, 0x74, 0x61, 0xa8, 0x33, 0x71, 0x32, 0x2b, 0x37, 0x77, 0x3d, 0x3d, 0xa1, 0x78, 0x00}},
+		{"tagged disabled", `{"$ext":1,"data":""}`, config{},
+			[]byte{0x82, 0xa4, 0x24, 0x65, 0x78, 0x74, 0x01, 0xa4, 0x64, 0x61, 0x74, 0x61, 0xa0}},
+		{"pairs", `{"$pairs":[[1,"a"],[[1,2],"b"]]}`, config{keys: "pairs"}, []byte{0x82, 0x01, 0xa1, 0x61, 0x92, 0x01, 0x02, 0xa1, 0x62}},
+		{"json keys", `{"$json_keys":{"1":"a","[1,2]":"b","\"c\"":true}}`, config{keys: "json"},
+			[]byte{0x83, 0x01, 0xa1, 0x61, 0x92, 0x01, 0x02, 0xa1, 0x62, 0xa1, 0x63, 0xc3}},
+		{"json nested keys", `{"$json_keys":{"{\"$pairs\":[[[1],2]]}":3,"{\"$json_keys\":{\"1\":1}}":4}}`, config{keys: "json"},
+			[]byte{0x82, 0x81, 0x91, 0x01, 0x02, 0x03, 0x81, 0x01, 0x01, 0x04}},
+		{"json tagged bin key", `{"$json_keys":{"{\"$bin\":\"/w==\",\"encoding\":\"base64\"}":1}}`, config{keys: "json", binEnc: "tagged"},
+			[]byte{0x81, 0xc4, 0x01, 0xff, 0x01}},
+		{"not pairs", `{"$pairs":[[1]]}`, config{keys: "pairs"}, []byte{0x81, 0xa6, 0x24, 0x70, 0x61, 0x69, 0x72, 0x73, 0x91, 0x91, 0x01}},
+		{"json keys disabled", `{"$json_keys":{"1":2}}`, config{keys: "pairs"},
+			[]byte{0x81, 0xaa, 0x24, 0x6a, 0x73, 0x6f, 0x6e, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x81, 0xa1, 0x31, 0x02}},
+		{"keys disabled", `{"$pairs":[[1,2]]}`, config{}, []byte{0x81, 0xa6, 0x24, 0x70, 0x61, 0x69, 0x72, 0x73, 0x91, 0x92, 0x01, 0x02}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		if err := v.cnf.compilePaths(); err != nil {
+			t.Errorf("%s: compilePaths error %s", v.casename, err)
+			continue
+		}
+		ret := convert(strings.NewReader(v.json), &buf, &v.cnf)
+		if ret != 0 {
+			t.Errorf("%s: convert returns %d", v.casename, ret)
+		}
+		if !bytes.Equal(buf.Bytes(), v.expected) {
+			t.Errorf("%s: mismatch. given: %x expected: %x", v.casename, buf.Bytes(), v.expected)
+		}
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	type testcase struct {
+		casename string
+		json     string
+		cnf      config
+	}
+
+	cases := []testcase{
+		{"broken json", `{"a":`, config{}},
+		{"shorten array", `[1,2`, config{}},
+		{"not RFC3339", `{"time":"yesterday"}`, config{timestampPaths: pathList{"$.time"}}},
+		{"not hex", `"deadbeef"`, config{binPaths: pathList{"$"}, binEnc: "hex"}},
+		{"not base64", `"!"`, config{binPaths: pathList{"$"}, binEnc: "base64"}},
+		{"tagged unknown encoding", `{"$bin":"AA==","encoding":"base32"}`, config{binEnc: "tagged"}},
+		{"tagged broken data", `{"$ext":1,"data":"!"}`, config{binEnc: "tagged"}},
+		{"json keys broken key", `{"$json_keys":{"[1":2}}`, config{keys: "json"}},
+		{"json keys two values", `{"$json_keys":{"1 2":3}}`, config{keys: "json"}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		v.cnf.compilePaths()
+		if convert(strings.NewReader(v.json), &buf, &v.cnf) == 0 {
+			t.Errorf("%s: No error is detected", v.casename)
+		}
+	}
+}
+
+func TestConvertKeysRoundTrip(t *testing.T) {
+	/* {1:"a", "b":[1], [1,{2:3}]:nil, {{[1]:2}:3}:true, 0xff(bin):{"1":1, 1:-1}} */
+	in := []byte{0x85, 0x01, 0xa1, 0x61, 0xa1, 0x62, 0x91, 0x01, 0x92, 0x01, 0x81, 0x02, 0x03, 0xc0,
+		0x81, 0x81, 0x91, 0x01, 0x02, 0x03, 0xc3, 0xc4, 0x01, 0xff, 0x82, 0xa1, 0x31, 0x01, 0x01, 0xff}
+	obj, err := msgpack.Decode(bytes.NewBuffer(in))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+
+	buf := bytes.Buffer{}
+	for _, keys := range []string{render.KeysJSON, render.KeysPairs} {
+		out, err := render.Marshal(obj, &render.Options{Keys: keys, Bin: render.BinTagged})
+		if err != nil {
+			t.Fatalf("%s: Marshal error %s", keys, err)
+		}
+		buf.Reset()
+		if ret := convert(bytes.NewReader(out), &buf, &config{keys: keys, binEnc: "tagged"}); ret != 0 {
+			t.Errorf("%s: convert returns %d", keys, ret)
+		}
+		if !bytes.Equal(buf.Bytes(), in) {
+			t.Errorf("%s: mismatch. JSON: %s\n given: %x\n expected: %x", keys, out, buf.Bytes(), in)
+		}
+	}
+}
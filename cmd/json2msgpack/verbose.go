@@ -0,0 +1,354 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+/* Functions to read verbose JSON of msgpack2json. */
+
+// verboseNode represents an object of verbose JSON.
+type verboseNode struct {
+	Format string          `json:"format"`
+	Header string          `json:"header"`
+	Type   *int8           `json:"type"`
+	Raw    string          `json:"raw"`
+	Value  json.RawMessage `json:"value"`
+
+	Decoded json.RawMessage `json:"decoded"` /* decoded value of ext. e.g. {"sec":1,"nsec":0} of time */
+}
+
+// verboseKV represents a key-value pair of map in verbose JSON.
+type verboseKV struct {
+	Key   *verboseNode `json:"key"`
+	Value *verboseNode `json:"value"`
+}
+
+// timeLayout is the layout of time.Time.String which msgpack2json uses for time ext by default.
+const timeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// parseTime parses the value of time ext.
+// The value is time.Time.String, RFC 3339 or other format selected by -time-format of msgpack2json.
+// "decoded" is used if the format is unknown.
+func (n *verboseNode) parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{timeLayout, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	d := struct {
+		Sec  *int64 `json:"sec"`
+		Nsec *int64 `json:"nsec"`
+	}{}
+	if len(n.Decoded) > 0 && json.Unmarshal(n.Decoded, &d) == nil && d.Sec != nil && d.Nsec != nil {
+		return time.Unix(*d.Sec, *d.Nsec), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither hex nor time", s)
+}
+
+// numberLong returns the integer of {"$numberLong":"..."} which msgpack2json -int64 long shows.
+func numberLong(raw json.RawMessage) (string, bool) {
+	l := struct {
+		Value *string `json:"$numberLong"`
+	}{}
+	if json.Unmarshal(raw, &l) != nil || l.Value == nil {
+		return "", false
+	}
+	return *l.Value, true
+}
+
+// isInteger reports whether b is the first byte of int family, uint family or fixint.
+func isInteger(b byte) bool {
+	return b <= 0x7f || b >= 0xe0 || (b >= msgpack.Uint8Format && b <= msgpack.Int64Format)
+}
+
+func parseHex(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("%q is not 0x-prefixed hex", s)
+	}
+	return hex.DecodeString(s[2:])
+}
+
+// original returns the object decoded from "raw".
+func (n *verboseNode) original() *msgpack.MPObject {
+	raw, err := parseHex(n.Raw)
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	obj, err := msgpack.Decode(bytes.NewBuffer(raw))
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// isEdited reports whether the value differs from the value of orig.
+func (n *verboseNode) isEdited(orig *msgpack.MPObject, b byte) bool {
+	if orig == nil || orig.FirstByte != b {
+		return true
+	}
+	if n.Type != nil && *n.Type != orig.ExtType {
+		return true
+	}
+	value := string(bytes.TrimSpace(n.Value))
+	if orig.IsNil() {
+		return value != "null"
+	}
+	if f, ok := orig.Float(); ok && (math.IsNaN(f) || math.IsInf(f, 0)) && value == "null" {
+		/* msgpack2json shows NaN and infinity as null by default */
+		return false
+	}
+	if l, ok := numberLong(n.Value); ok {
+		return l != orig.DataStr
+	}
+	var s string
+	if json.Unmarshal(n.Value, &s) == nil {
+		if t, ok := orig.Time(); ok {
+			/* the format of time depends on the options of msgpack2json */
+			v, err := n.parseTime(s)
+			return err != nil || !v.Equal(t)
+		}
+		if orig.InvalidUTF8 != nil && (s == strings.ToValidUTF8(orig.DataStr, "\ufffd") || s == fmt.Sprintf("0x%x", orig.DataStr)) {
+			/* msgpack2json shows invalid UTF-8 as replaced or hex */
+			return false
+		}
+		return s != orig.DataStr
+	}
+	return value != orig.DataStr
+}
+
+func (n *verboseNode) header() (byte, error) {
+	b, err := parseHex(n.Header)
+	if err != nil || len(b) != 1 {
+		return 0, fmt.Errorf("invalid header %q", n.Header)
+	}
+	return b[0], nil
+}
+
+// readVerbose reads verbose JSON from in and converts each object into MPObject.
+func readVerbose(in io.Reader, f func(*msgpack.MPObject) error) error {
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	for {
+		n := &verboseNode{}
+		err := dec.Decode(n)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		obj, err := n.toObject("$")
+		if err != nil {
+			return err
+		}
+		if err := f(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// toObject converts n into MPObject.
+// The format of "header" is kept unless the value does not fit it.
+func (n *verboseNode) toObject(path string) (*msgpack.MPObject, error) {
+	b, err := n.header()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	switch {
+	case msgpack.IsArray(b):
+		children := []*verboseNode{}
+		if err := json.Unmarshal(n.Value, &children); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		obj := &msgpack.MPObject{FirstByte: b, FormatName: n.Format}
+		for i, v := range children {
+			child, err := v.toObject(fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			obj.Child = append(obj.Child, child)
+		}
+		obj.Length = uint32(len(obj.Child))
+		if obj.CheckFormat() != nil {
+			return msgpack.NewArray(obj.Child), nil
+		}
+		return obj, nil
+	case msgpack.IsMap(b):
+		kvs := []verboseKV{}
+		if err := json.Unmarshal(n.Value, &kvs); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		obj := &msgpack.MPObject{FirstByte: b, FormatName: n.Format}
+		for i, v := range kvs {
+			if v.Key == nil || v.Value == nil {
+				return nil, fmt.Errorf("%s: key or value is missing at %d", path, i)
+			}
+			key, err := v.Key.toObject(path)
+			if err != nil {
+				return nil, err
+			}
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			if s, ok := key.Str(); ok {
+				childPath = keyPath(path, s)
+			}
+			value, err := v.Value.toObject(childPath)
+			if err != nil {
+				return nil, err
+			}
+			obj.Child = append(obj.Child, key, value)
+		}
+		obj.Length = uint32(len(obj.Child) / 2)
+		if obj.CheckFormat() != nil {
+			return msgpack.NewMap(obj.Child), nil
+		}
+		return obj, nil
+	}
+
+	orig := n.original()
+	if !n.isEdited(orig, b) {
+		/* keep the original bytes */
+		return orig, nil
+	}
+
+	obj := &msgpack.MPObject{FirstByte: b, FormatName: n.Format}
+	fallback, err := n.setValue(obj)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if obj.CheckFormat() != nil {
+		/* the value does not fit the format */
+		return fallback, nil
+	}
+	return obj, nil
+}
+
+// setValue sets the edited value to obj.
+// It returns the object with the smallest format for the value which is used if the value does not fit obj.
+func (n *verboseNode) setValue(obj *msgpack.MPObject) (*msgpack.MPObject, error) {
+	b := obj.FirstByte
+	var v interface{}
+	if err := json.Unmarshal(n.Value, &v); err != nil {
+		return nil, err
+	}
+
+	if l, ok := numberLong(n.Value); ok {
+		return setNumber(obj, json.Number(l))
+	}
+
+	switch val := v.(type) {
+	case nil:
+		obj.SetValue(nil)
+		return msgpack.NewNil(), nil
+	case bool:
+		obj.SetValue(val)
+		return msgpack.NewBool(val), nil
+	case float64:
+		/* json.Unmarshal into interface{} uses float64. Parse the number again to keep precision. */
+		return setNumber(obj, json.Number(strings.TrimSpace(string(n.Value))))
+	case string:
+		switch {
+		case isInteger(b):
+			/* integer quoted by msgpack2json -int64 */
+			if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return setNumber(obj, json.Number(val))
+			}
+			if _, err := strconv.ParseUint(val, 10, 64); err == nil {
+				return setNumber(obj, json.Number(val))
+			}
+		case msgpack.IsBin(b):
+			data, err := parseHex(val)
+			if err != nil {
+				return nil, err
+			}
+			obj.SetValue(data)
+			return msgpack.NewBin(data), nil
+		case msgpack.IsExt(b):
+			if n.Type == nil {
+				return nil, fmt.Errorf("type of ext is missing")
+			}
+			obj.ExtType = *n.Type
+			if data, err := parseHex(val); err == nil {
+				obj.SetValue(msgpack.Ext{Type: *n.Type, Data: data})
+				return msgpack.NewExt(*n.Type, data), nil
+			}
+			t, err := n.parseTime(val)
+			if err != nil {
+				return nil, err
+			}
+			obj.SetValue(t)
+			if *n.Type == 0 {
+				return msgpack.NewEventTime(t), nil
+			}
+			return msgpack.NewTimestamp(t), nil
+		}
+		obj.SetValue(val)
+		return msgpack.NewStr(val), nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", string(n.Value))
+}
+
+// setNumber sets the number to obj of int family, uint family, fixint or float family.
+func setNumber(obj *msgpack.MPObject, num json.Number) (*msgpack.MPObject, error) {
+	b := obj.FirstByte
+	if b == msgpack.Float32Format || b == msgpack.Float64Format {
+		f, err := num.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if b == msgpack.Float32Format && float64(float32(f)) == f {
+			obj.SetValue(float32(f))
+		} else {
+			obj.SetValue(f)
+		}
+		if b == msgpack.Float32Format {
+			return msgpack.NewFloat(f), nil
+		}
+		return msgpack.NewFloat64(f), nil
+	}
+	/* int family keeps signed integer even if the value is not negative */
+	ret, err := newNumber(num, &config{signedInt: b >= msgpack.Int8Format && b <= msgpack.Int64Format})
+	if err != nil {
+		return nil, err
+	}
+	obj.SetValue(ret.Value)
+	return ret, nil
+}
+
+// reverse reads verbose JSON from in and writes MessagePack to out.
+func reverse(in io.Reader, out io.Writer) int {
+	err := readVerbose(in, func(obj *msgpack.MPObject) error {
+		return msgpack.Encode(out, obj)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error(%s) detected. Incoming data may be broken.\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverse(t *testing.T) {
+	type testcase struct {
+		casename string
+		json     string
+		expected []byte
+	}
+
+	tm := fmt.Sprintf("%v", time.Unix(1, 0))
+	tm2 := fmt.Sprintf("%v", time.Unix(2, 0))
+
+	cases := []testcase{
+		{"unchanged uint32", `{"format":"uint 32", "header":"0xce", "raw":"0xce00000005", "value":5}`, []byte{0xce, 0x00, 0x00, 0x00, 0x05}},
+		{"edited uint32", `{"format":"uint 32", "header":"0xce", "raw":"0xce00000005", "value":6}`, []byte{0xce, 0x00, 0x00, 0x00, 0x06}},
+		{"uint8 overflow", `{"format":"uint 8", "header":"0xcc", "raw":"0xcc05", "value":300}`, []byte{0xcd, 0x01, 0x2c}},
+		{"uint8 negative", `{"format":"uint 8", "header":"0xcc", "raw":"0xcc05", "value":-1}`, []byte{0xff}},
+		{"int16 positive", `{"format":"int 16", "header":"0xd1", "raw":"0xd10001", "value":2}`, []byte{0xd1, 0x00, 0x02}},
+		{"uint64 quoted", `{"format":"uint 64", "header":"0xcf", "raw":"0xcf0020000000000001", "value":"9007199254740993"}`, []byte{0xcf, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{"uint64 quoted edited", `{"format":"uint 64", "header":"0xcf", "raw":"0xcf0020000000000001", "value":"18446744073709551615"}`, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"int64 numberLong", `{"format":"int 64", "header":"0xd3", "raw":"0xd3ffdfffffffffffff", "value":{"$numberLong":"-9007199254740993"}}`, []byte{0xd3, 0xff, 0xdf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"int64 numberLong edited", `{"format":"int 64", "header":"0xd3", "raw":"0xd3ffdfffffffffffff", "value":{"$numberLong":"1"}}`, []byte{0xd3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{"fixint to float", `{"format":"positive fixint", "header":"0x01", "raw":"0x01", "value":1.5}`, []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float64 unchanged", `{"format":"float 64", "header":"0xcb", "raw":"0xcb3fb999999999999a", "value":0.1}`, []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"float64 NaN as null", `{"format":"float 64", "header":"0xcb", "raw":"0xcb7ff8000000000001", "bits":"0x7ff8000000000001", "value":null}`, []byte{0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{"float32 Inf as string", `{"format":"float 32", "header":"0xca", "raw":"0xca7f800000", "bits":"0x7f800000", "value":"+Inf"}`, []byte{0xca, 0x7f, 0x80, 0x00, 0x00}},
+		{"float32 edited", `{"format":"float 32", "header":"0xca", "raw":"0xca00000000", "value":1.5}`, []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float32 to float64", `{"format":"float 32", "header":"0xca", "raw":"0xca00000000", "value":0.1}`, []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"str8 unchanged", `{"format":"str 8", "header":"0xd9", "raw":"0xd90141", "value":"A"}`, []byte{0xd9, 0x01, 0x41}},
+		{"str8 edited", `{"format":"str 8", "header":"0xd9", "raw":"0xd90141", "value":"AB"}`, []byte{0xd9, 0x02, 0x41, 0x42}},
+		{"fixstr overflow", `{"format":"fixstr", "header":"0xa1", "raw":"0xa141", "value":"` + strings.Repeat("A", 32) + `"}`,
+			append([]byte{0xd9, 0x20}, []byte(strings.Repeat("A", 32))...)},
+		{"invalid UTF-8 replaced", `{"format":"fixstr", "header":"0xa3", "raw":"0xa341ff42", "invalid_utf8":2, "value":"A\ufffdB"}`, []byte{0xa3, 0x41, 0xff, 0x42}},
+		{"invalid UTF-8 hex", `{"format":"fixstr", "header":"0xa3", "raw":"0xa341ff42", "invalid_utf8":2, "value":"0x41ff42"}`, []byte{0xa3, 0x41, 0xff, 0x42}},
+		{"invalid UTF-8 edited", `{"format":"fixstr", "header":"0xa3", "raw":"0xa341ff42", "invalid_utf8":2, "value":"ABC"}`, []byte{0xa3, 0x41, 0x42, 0x43}},
+		{"true to false", `{"format":"true", "header":"0xc3", "raw":"0xc3", "value":false}`, []byte{0xc2}},
+		{"nil", `{"format":"nil", "header":"0xc0", "raw":"0xc0", "value":null}`, []byte{0xc0}},
+		{"bin8 edited", `{"format":"bin 8", "header":"0xc4", "raw":"0xc401ff", "value":"0xdead"}`, []byte{0xc4, 0x02, 0xde, 0xad}},
+		{"fixext1 edited", `{"format":"fixext 1", "header":"0xd4", "type":1, "raw":"0xd401ff", "value":"0x01"}`, []byte{0xd4, 0x01, 0x01}},
+		{"fixext1 type edited", `{"format":"fixext 1", "header":"0xd4", "type":2, "raw":"0xd401ff", "value":"0xff"}`, []byte{0xd4, 0x02, 0xff}},
+		{"fixext1 to fixext2", `{"format":"fixext 1", "header":"0xd4", "type":1, "raw":"0xd401ff", "value":"0xdead"}`, []byte{0xd5, 0x01, 0xde, 0xad}},
+		{"timestamp unchanged", `{"format":"timestamp 32", "header":"0xd6", "type":-1, "raw":"0xd6ff00000001", "value":"` + tm + `"}`, []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{"timestamp edited", `{"format":"timestamp 32", "header":"0xd6", "type":-1, "raw":"0xd6ff00000001", "value":"` + tm2 + `"}`, []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x02}},
+		{"timestamp rfc3339", `{"format":"timestamp 32", "header":"0xd6", "type":-1, "raw":"0xd6ff00000001", "value":"1970-01-01T09:00:01+09:00"}`, []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{"timestamp rfc3339 edited", `{"format":"timestamp 64", "header":"0xd7", "type":-1, "raw":"0xd7ff0000000000000001", "value":"1970-01-01T00:00:02.5Z"}`, []byte{0xd7, 0xff, 0x77, 0x35, 0x94, 0x00, 0x00, 0x00, 0x00, 0x02}},
+		{"timestamp unix", `{"format":"timestamp 32", "header":"0xd6", "type":-1, "raw":"0xd6ff00000001", "decoded":{"sec":1,"nsec":0}, "value":"1"}`, []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{"event time unix", `{"format":"event time", "header":"0xd7", "type":0, "raw":"0xd7000000000100000001", "decoded":{"sec":1,"nsec":1}, "value":"1.000000001"}`, []byte{0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}},
+		{"no raw", `{"format":"uint 16", "header":"0xcd", "value":1}`, []byte{0xcd, 0x00, 0x01}},
+		{"array16", `{"format":"array 16", "header":"0xdc", "length":1, "raw":"0xdc000101", "value":[
+			{"format":"positive fixint", "header":"0x01", "raw":"0x01", "value":1},
+			{"format":"uint 16", "header":"0xcd", "raw":"0xcd0002", "value":2}]}`, []byte{0xdc, 0x00, 0x02, 0x01, 0xcd, 0x00, 0x02}},
+		{"map16", `{"format":"map 16", "header":"0xde", "length":1, "raw":"0xde0001a14101", "value":[
+			{"key": {"format":"fixstr", "header":"0xa1", "raw":"0xa141", "value":"A"},
+			 "value": {"format":"positive fixint", "header":"0x01", "raw":"0x01", "value":1}}]}`, []byte{0xde, 0x00, 0x01, 0xa1, 0x41, 0x01}},
+		{"ndjson", "{\"format\":\"nil\", \"header\":\"0xc0\", \"raw\":\"0xc0\", \"value\":null}\n{\"format\":\"true\", \"header\":\"0xc3\", \"raw\":\"0xc3\", \"value\":true}\n", []byte{0xc0, 0xc3}},
+	}
+
+	/* fixarray overflow */
+	children := []string{}
+	expected := []byte{0xdc, 0x00, 0x10}
+	for i := 0; i < 16; i++ {
+		children = append(children, `{"format":"nil", "header":"0xc0", "raw":"0xc0", "value":null}`)
+		expected = append(expected, 0xc0)
+	}
+	cases = append(cases, testcase{"fixarray overflow", `{"format":"fixarray", "header":"0x90", "length":0, "raw":"0x90", "value":[` + strings.Join(children, ",") + `]}`, expected})
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		ret := reverse(strings.NewReader(v.json), &buf)
+		if ret != 0 {
+			t.Errorf("%s: reverse returns %d", v.casename, ret)
+		}
+		if !bytes.Equal(buf.Bytes(), v.expected) {
+			t.Errorf("%s: mismatch. given: %x expected: %x", v.casename, buf.Bytes(), v.expected)
+		}
+	}
+}
+
+func TestReverseError(t *testing.T) {
+	cases := map[string]string{
+		"broken json":    `{"format":"nil", "header":"0xc0"`,
+		"invalid header": `{"format":"nil", "header":"c0", "value":null}`,
+		"bin not hex":    `{"format":"bin 8", "header":"0xc4", "value":"AB"}`,
+		"ext no type":    `{"format":"fixext 1", "header":"0xd4", "value":"0x01"}`,
+		"map no value":   `{"format":"fixmap", "header":"0x81", "value":[{"key":{"format":"nil", "header":"0xc0", "value":null}}]}`,
+	}
+
+	buf := bytes.Buffer{}
+	for name, v := range cases {
+		buf.Reset()
+		if reverse(strings.NewReader(v), &buf) == 0 {
+			t.Errorf("%s: No error is detected", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+/* Functions to output the document of msgpack-inspect. https://github.com/tagomoris/msgpack-inspect */
+
+// document frames the objects of an input like msgpack-inspect.
+// inspect-json is an array of the objects and inspect-yaml is a sequence of them.
+type document struct {
+	format string
+	n      int /* number of objects written */
+}
+
+// writer returns a writer of the next object to out.
+func (d *document) writer(out io.Writer) *objectWriter {
+	w := &objectWriter{out: out}
+	if d.format == "inspect-yaml" {
+		w.prefix = "\n"
+		if d.n == 0 {
+			w.prefix = "---\n"
+		}
+	} else {
+		w.prefix = ",\n  "
+		if d.n == 0 {
+			w.prefix = "[\n  "
+		}
+		/* indent the object as an element of the array */
+		w.indent = "  "
+	}
+	return w
+}
+
+// close finishes the document. Nothing is written for an input without objects like -format json.
+func (d *document) close(out io.Writer) {
+	if d.n == 0 {
+		return
+	}
+	if d.format == "inspect-json" {
+		fmt.Fprint(out, "\n]\n")
+	} else {
+		fmt.Fprint(out, "\n")
+	}
+}
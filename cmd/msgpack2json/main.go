@@ -17,18 +17,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/mattn/go-isatty"
 	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack/render"
 )
 
 const version string = "1.0.0"
@@ -39,6 +40,18 @@ type config struct {
 	eventTime  bool
 	serverPort uint
 	rawmode    bool
+	lint       bool
+	strict     bool
+	utf8       string /* how to show invalid UTF-8 str. replace, escape or hex */
+	nonFinite  string /* how to show NaN and infinity. null, string or error */
+	keys       string /* how to show map which has keys other than str in raw mode. string, json, pairs or error */
+	int64      string /* how to show integer which JSON parsers may round. number, safe, string or long */
+	binEnc     string /* how to show bin and ext which is not decoded in raw mode. hex, base64, base64url or tagged */
+	format     string /* output format. json, inspect-json or inspect-yaml */
+	limits     msgpack.Limits
+	query      *msgpack.Selector /* output only the matched objects if set */
+	exts       *msgpack.ExtRegistry
+	times      *msgpack.TimeFormat /* how to show time. nil means the default */
 }
 
 type serverHandler struct {
@@ -46,34 +59,183 @@ type serverHandler struct {
 }
 
 func decodeAndOutput(in io.Reader, out io.Writer, file string, cnf *config) int {
-	b, err := ioutil.ReadAll(in)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ioutil.ReadAll :%v\n", err)
-		return 1
+	ret := 0
+	opts := []msgpack.DecoderOption{msgpack.WithLimits(cnf.limits), msgpack.WithExtRegistry(cnf.exts)}
+	if cnf.strict {
+		opts = append(opts, msgpack.Strict())
 	}
-
-	buf := bytes.NewBuffer(b)
-	for buf.Len() > 0 {
-		ret, err := msgpack.Decode(buf)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error(%s) detected. Incoming data may be broken.\n", err)
-			if ret == nil {
+	if cnf.times != nil {
+		opts = append(opts, msgpack.WithTimeFormat(*cnf.times))
+	}
+	dec := msgpack.NewDecoder(in, opts...)
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+	out = bw
+	var doc *document
+	if (cnf.format == "inspect-json" || cnf.format == "inspect-yaml") && !cnf.lint {
+		doc = &document{format: cnf.format}
+		defer doc.close(out)
+	}
+	for {
+		obj, err := dec.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			bw.Flush() /* keep the order of the output and the error on a terminal */
+			printDecodeError(os.Stderr, err)
+			if obj == nil {
 				return 1
 			}
-			/* ret is broken, but try to output as much as possible. */
+			/* obj is broken, but try to output as much as possible. */
 		}
+		if cnf.lint {
+			if outputLint(obj, out, file, cnf) {
+				ret = 1
+			}
+		} else if cnf.query != nil {
+			for _, m := range cnf.query.Select(obj) {
+				if output(m.Obj, out, doc, file, cnf) {
+					ret = 1
+				}
+			}
+		} else if output(obj, out, doc, file, cnf) {
+			ret = 1
+		}
+		if err != nil {
+			/* the rest of the input can not be trusted */
+			return 1
+		}
+	}
+
+	return ret
+}
+
+// output prints obj as a line of JSON, or an object of the document of -format inspect-json and inspect-yaml.
+// doc is nil for -format json.
+// It returns true if obj is not printed because it can not be converted, e.g. NaN with -non-finite error.
+func output(obj *msgpack.MPObject, out io.Writer, doc *document, file string, cnf *config) bool {
+	var w *objectWriter
+	if doc != nil {
+		w = doc.writer(out)
+	} else {
+		w = &objectWriter{out: out}
+		if cnf.showSource {
+			w.prefix = file + ": "
+		}
+	}
+	/* render.Write writes nothing if obj can not be converted, and then the prefix is not written either */
+	if err := render.Write(w, obj, cnf.renderOptions()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error(%s) detected.\n", err)
+		return true
+	}
+	if doc != nil {
+		doc.n++
+	} else {
+		fmt.Fprintf(out, "\n")
+	}
+	return false
+}
+
+// objectWriter writes an object to out as it is rendered.
+// prefix is written with the first byte, so nothing is written for an object which is not rendered.
+type objectWriter struct {
+	out    io.Writer
+	prefix string
+	indent string /* written after each newline */
+}
+
+// Write implements io.Writer.
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if w.prefix != "" {
+		if _, err := io.WriteString(w.out, w.prefix); err != nil {
+			return 0, err
+		}
+		w.prefix = ""
+	}
+	if w.indent == "" {
+		return w.out.Write(p)
+	}
+	n := 0
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m, err := w.out.Write(p)
+			return n + m, err
+		}
+		m, err := w.out.Write(p[:i+1])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.WriteString(w.out, w.indent); err != nil {
+			return n, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// renderOptions converts the config into the options of package render.
+func (cnf *config) renderOptions() *render.Options {
+	opts := &render.Options{
+		Verbose:   !cnf.rawmode,
+		UTF8:      cnf.utf8,
+		NonFinite: cnf.nonFinite,
+		Keys:      cnf.keys,
+		Int64:     cnf.int64,
+		Bin:       cnf.binEnc,
+		Time:      cnf.times,
+		Exts:      cnf.exts,
+		ErrorLog:  os.Stderr,
+	}
+	switch cnf.format {
+	case "inspect-json":
+		opts.Inspect = render.InspectJSON
+		opts.Indent = "  "
+	case "inspect-yaml":
+		opts.Inspect = render.InspectYAML
+	}
+	return opts
+}
+
+// outputLint prints findings of obj line by line.
+// It returns true if there is a finding of error severity.
+func outputLint(obj *msgpack.MPObject, out io.Writer, file string, cnf *config) bool {
+	hasError := false
+	for _, v := range msgpack.Lint(obj) {
 		if cnf.showSource {
 			fmt.Fprintf(out, "%s: ", file)
 		}
-		if cnf.rawmode {
-			outputJSON(ret, out, 0)
-		} else {
-			outputVerboseJSON(ret, out, 0)
+		fmt.Fprintln(out, v)
+		if v.Severity == msgpack.SeverityError {
+			hasError = true
 		}
-		fmt.Fprintf(out, "\n")
 	}
+	return hasError
+}
 
-	return 0
+// printDecodeError prints err in a human-readable form.
+func printDecodeError(out io.Writer, err error) {
+	var e *msgpack.DecodeError
+	if !errors.As(err, &e) {
+		fmt.Fprintf(out, "Error(%s) detected. Incoming data may be broken.\n", err)
+		return
+	}
+
+	kind := "decode error"
+	if e.Kind != nil {
+		kind = e.Kind.Error()
+	}
+	fmt.Fprintf(out, "Error(%s) detected. Incoming data may be broken.\n", kind)
+	fmt.Fprintf(out, "    offset: %d (0x%x)\n", e.Offset, e.Offset)
+	fmt.Fprintf(out, "    path:   %s\n", e.Path)
+	fmt.Fprintf(out, "    format: %s\n", e.Format)
+	if e.Need > 0 {
+		fmt.Fprintf(out, "    needed: %d bytes, available: %d bytes\n", e.Need, e.Have)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(out, "    cause:  %s\n", e.Err)
+	}
 }
 
 func (h *serverHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -118,143 +280,54 @@ func readFiles(files []string, cnf *config) {
 	}
 }
 
-func outputVerboseKV(obj *msgpack.MPObject, i uint32, out io.Writer, nest int) {
-	spaces := strings.Repeat("    ", nest)
-
-	fmt.Fprintf(out, "%s{\"key\":\n", spaces)
-	outputVerboseJSON(obj.Child[i*2], out, nest+1)
-	fmt.Fprint(out, ",\n")
-	fmt.Fprintf(out, "%s \"value\":\n", spaces)
-	outputVerboseJSON(obj.Child[i*2+1], out, nest+1)
-	fmt.Fprintf(out, "\n%s}", spaces)
-}
-
-func outputVerboseJSON(obj *msgpack.MPObject, out io.Writer, nest int) {
-	if obj == nil {
-		return
+// timeFormat converts the values of -time-zone and -time-format into TimeFormat.
+func timeFormat(zone string, layout string) (*msgpack.TimeFormat, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
 	}
-	spaces := strings.Repeat("    ", nest)
-
-	switch {
-	case msgpack.IsArray(obj.FirstByte):
-		spaces2 := strings.Repeat("    ", nest+1)
-
-		// array header info
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "length":%d, "raw":"0x%0x", "value":`, spaces, obj.FormatName, obj.FirstByte, obj.Length, obj.Raw)
-
-		if int(obj.Length) != len(obj.Child) {
-			fmt.Fprintf(os.Stderr, "Error: size mismatch. length is %d, buf %d children.\n", obj.Length, len(obj.Child))
-			return
-		}
-
-		// array body info
-		fmt.Fprintf(out, "\n%s[\n", spaces2)
-		if obj.Length > 0 {
-			var i uint32
-			for i = 0; i < obj.Length-1; i++ {
-				outputVerboseJSON(obj.Child[i], out, nest+2)
-				fmt.Fprintf(out, ",\n")
-			}
-			outputVerboseJSON(obj.Child[obj.Length-1], out, nest+2)
-		}
-		fmt.Fprintf(out, "\n%s]\n%s}\n", spaces2, spaces)
-	case msgpack.IsMap(obj.FirstByte):
-		spaces2 := strings.Repeat("    ", nest+1)
-		// map header info
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "length":%d, "raw":"0x%0x", "value":`, spaces, obj.FormatName, obj.FirstByte, obj.Length, obj.Raw)
-
-		if int(obj.Length*2) != len(obj.Child) {
-			fmt.Fprintf(os.Stderr, "Error: size mismatch. length is %d, buf %d(!=length*2) children.\n", obj.Length, len(obj.Child))
-			return
-		}
-
-		// map body info
-		fmt.Fprintf(out, "\n%s[\n", spaces2)
-		var i uint32
-		if obj.Length > 0 {
-			for i = 0; i < obj.Length-1; i++ {
-				outputVerboseKV(obj, i, out, nest+2)
-				fmt.Fprint(out, ",\n")
-			}
-			outputVerboseKV(obj, obj.Length-1, out, nest+2)
-		}
-		fmt.Fprintf(out, "\n%s]\n%s}", spaces2, spaces)
-
-	case msgpack.IsString(obj.FirstByte) || msgpack.IsBin(obj.FirstByte):
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "raw":"0x%0x", "value":"%s"}`, spaces, obj.FormatName, obj.FirstByte, obj.Raw, obj.DataStr)
-	case msgpack.IsExt(obj.FirstByte):
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "type":%d, "raw":"0x%0x", "value":"%s"}`, spaces, obj.FormatName, obj.FirstByte, obj.ExtType, obj.Raw, obj.DataStr)
-	case msgpack.NilFormat == obj.FirstByte:
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "raw":"0x%0x", "value":null}`, spaces, obj.FormatName, obj.FirstByte, obj.Raw)
-	case msgpack.NeverUsedFormat == obj.FirstByte:
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "raw":"0x%0x", "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Raw, obj.DataStr)
-		fmt.Fprintf(os.Stderr, "Error: Never Used Format detected\n")
-		return
-	default:
-		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "raw":"0x%0x", "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Raw, obj.DataStr)
-	}
-}
-
-func outputKV(obj *msgpack.MPObject, i uint32, out io.Writer, nest int) {
-	outputJSON(obj.Child[i*2], out, nest)
-	fmt.Fprint(out, ":")
-	outputJSON(obj.Child[i*2+1], out, nest)
-}
-
-func outputJSON(obj *msgpack.MPObject, out io.Writer, nest int) {
-	switch {
-	case msgpack.IsMap(obj.FirstByte):
-		if int(obj.Length*2) != len(obj.Child) {
-			fmt.Fprintf(os.Stderr, "Error: size mismatch. length is %d, buf %d(!=length*2) children.\n", obj.Length, len(obj.Child))
-			return
-		}
-		fmt.Fprint(out, "{")
-		if obj.Length > 0 {
-			var i uint32
-			for i = 0; i < obj.Length-1; i++ {
-				outputKV(obj, i, out, nest+1)
-				fmt.Fprint(out, ",")
-			}
-			outputKV(obj, obj.Length-1, out, nest+1)
-		}
-		fmt.Fprint(out, "}")
-	case msgpack.IsArray(obj.FirstByte):
-		if int(obj.Length) != len(obj.Child) {
-			fmt.Fprintf(os.Stderr, "Error: size mismatch. length is %d, buf %d children.\n", obj.Length, len(obj.Child))
-			return
-		}
-		fmt.Fprint(out, "[")
-		if obj.Length > 0 {
-			var i uint32
-			for i = 0; i < obj.Length-1; i++ {
-				outputJSON(obj.Child[i], out, nest+1)
-				fmt.Fprint(out, ",")
-			}
-			outputJSON(obj.Child[obj.Length-1], out, nest+1)
-		}
-		fmt.Fprint(out, "]")
-	case msgpack.IsString(obj.FirstByte) || msgpack.IsBin(obj.FirstByte):
-		fmt.Fprintf(out, "\"%s\"", obj.DataStr)
-	case msgpack.NilFormat == obj.FirstByte:
-		fmt.Fprintf(out, "null")
-	case msgpack.NeverUsedFormat == obj.FirstByte:
-		fmt.Fprintf(os.Stderr, "Error: Never Used Format detected\n")
-		return
-	default:
-		fmt.Fprint(out, obj.DataStr)
+	f := &msgpack.TimeFormat{Location: loc, Layout: layout}
+	switch layout {
+	case "rfc3339":
+		f.Layout = time.RFC3339
+	case "rfc3339nano":
+		f.Layout = time.RFC3339Nano
+	case "unix":
+		f.Layout = msgpack.TimeUnix
+	case "unixnano":
+		f.Layout = msgpack.TimeUnixNano
 	}
+	return f, nil
 }
 
 func cmdMain() int {
 	ret := 1
 	showVersion := false
+	query := ""
+	timeZone := ""
+	timeLayout := ""
 
 	config := config{}
 
 	flag.BoolVar(&config.showSource, "f", false, "show data source (e.g. stdin, filename)")
 	flag.BoolVar(&config.serverMode, "s", false, "http server mode")
 	flag.BoolVar(&config.rawmode, "r", false, "raw JSON mode")
+	flag.StringVar(&config.format, "format", "json", "output format: json, inspect-json or inspect-yaml (the schema of msgpack-inspect)")
+	flag.StringVar(&query, "q", "", "output only the objects matched by the query (e.g. $[1][*][1].log)")
+	flag.BoolVar(&config.lint, "lint", false, "report non-minimal and suspicious encodings instead of JSON")
+	flag.IntVar(&config.limits.MaxDepth, "max-depth", 0, "maximum nesting depth of array and map (0: unlimited)")
+	flag.IntVar(&config.limits.MaxLength, "max-length", 0, "maximum number of elements of array and map (0: unlimited)")
+	flag.IntVar(&config.limits.MaxSize, "max-size", 0, "maximum size of str, bin and ext in bytes (0: unlimited)")
+	flag.Int64Var(&config.limits.MaxBytes, "max-bytes", 0, "maximum total bytes of an input (0: unlimited)")
+	flag.BoolVar(&config.strict, "strict", false, "report str which is not valid UTF-8 and 0xc1 as an error")
+	flag.StringVar(&config.utf8, "utf8", "replace", "how to show str which is not valid UTF-8: replace, escape or hex")
+	flag.StringVar(&config.nonFinite, "non-finite", "null", "how to show NaN and infinity of float: null, string or error")
+	flag.StringVar(&config.keys, "keys", "string", "how to show map which has keys other than str in raw mode: string, json, pairs or error")
+	flag.StringVar(&config.int64, "int64", "number", "how to show integer which JSON parsers may round: number, safe, string or long")
+	flag.StringVar(&config.binEnc, "bin-encoding", "hex", "how to show bin and ext which is not decoded in raw mode: hex, base64, base64url or tagged")
 	flag.BoolVar(&config.eventTime, "e", false, "enable Fluentd event time ext format")
+	flag.StringVar(&timeZone, "time-zone", "Local", "time zone to show timestamp: Local, UTC or a name like Asia/Tokyo")
+	flag.StringVar(&timeLayout, "time-format", "", "format to show timestamp: rfc3339, rfc3339nano, unix, unixnano or a Go layout like \"2006-01-02 15:04:05\"")
 	flag.BoolVar(&showVersion, "v", false, "show version")
 	flag.UintVar(&config.serverPort, "p", 8080, "port number for server mode")
 
@@ -265,8 +338,44 @@ func cmdMain() int {
 		return 0
 	}
 
+	if err := config.renderOptions().Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch config.format {
+	case "json":
+	case "inspect-json", "inspect-yaml":
+		if config.rawmode || config.showSource {
+			fmt.Fprintf(os.Stderr, "-r and -f can not be used with -format %s\n", config.format)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -format value: %s\n", config.format)
+		return 1
+	}
+
+	if timeZone != "Local" || timeLayout != "" {
+		f, err := timeFormat(timeZone, timeLayout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -time-zone: %s\n", err)
+			return 1
+		}
+		config.times = f
+	}
+
+	if query != "" {
+		sel, err := msgpack.CompileQuery(query)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		config.query = sel
+	}
+
 	if config.eventTime {
-		msgpack.RegisterFluentdEventTime()
+		config.exts = msgpack.NewExtRegistry()
+		config.exts.RegisterFluentdEventTime()
 	}
 
 	if config.serverMode {
@@ -21,491 +21,732 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+	"math/rand"
 	"strings"
 	"testing"
+	"time"
 )
 
-func TestOutputJSON(t *testing.T) {
+type MPBase struct {
+	Format string `json:"format"`
+	Byte   string `json:"header"`
+	Raw    string `json:"raw"`
+}
+
+type MPString struct {
+	MPBase
+	Value string `json:"value"`
+}
+
+func TestOutputJSONEscape(t *testing.T) {
 	type testcase struct {
 		casename string
-		msgpdata []byte
+		obj      *msgpack.MPObject
 		expected string
 	}
 
+	special := "\"\\/\b\f\n\r\t\x00\x1f\x7f\u2028"
 	cases := []testcase{
-		{"fixstr", []byte{0xa2, 0x41, 0x42}, `"AB"`},
-		{"fixint", []byte{0x01}, "1"},
-		{"fixarray", []byte{0x92, 0x01, 0x02}, "[1,2]"},
-		{"array size 0", []byte{0x90}, "[]"},
-		{"fixmap", []byte{0x82, 0xa1, 0x41, 0x00, 0xa1, 0x42, 0x01}, `{"A":0,"B":1}`},
-		{"map size 0", []byte{0x80}, "{}"},
-		{"nested map", []byte{0x82, 0xa1, 0x30, 0xa1, 0x30, 0xa1, 0x31, 0x83, 0xa1, 0x32, 0xa1, 0x32, 0xa1, 0x33, 0xa1, 0x33, 0xa1, 0x34, 0xa1, 0x34}, `{"0":"0","1":{"2":"2","3":"3","4":"4"}}`},
-		{"nested array", []byte{0x82, 0xa1, 0x30, 0xa1, 0x30, 0xa1, 0x31, 0x93, 0x00, 0x01, 0x02}, `{"0":"0","1":[0,1,2]}`},
-
-		{"n fixint", []byte{0xff}, "-1"},
-		{"nil", []byte{0xc0}, "null"},
-		{"never used", []byte{0xc1}, ""},
-		{"true", []byte{0xc3}, "true"},
-		{"false", []byte{0xc2}, "false"},
-		{"float32", []byte{0xca, 0x80, 0x00, 0x00, 0x00}, "-0.000000"},
-		{"float64", []byte{0xcb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "-0.000000"},
-		{"uint8", []byte{0xcc, 0xff}, "255"},
-		{"uint16", []byte{0xcd, 0xff, 0x00}, "65280"},
-		{"uint32", []byte{0xce, 0xff, 0x00, 0xff, 0x00}, "4278255360"},
-		{"uint64", []byte{0xcf, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, "18374966859414961920"},
-		{"int8", []byte{0xd0, 0xff}, "-1"},
-		{"int16", []byte{0xd1, 0xff, 0x00}, "-256"},
-		{"int32", []byte{0xd2, 0xff, 0x00, 0xff, 0x00}, "-16711936"},
-		{"int64", []byte{0xd3, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, "-71777214294589696"},
-		{"str8", []byte{0xd9, 0x0f, 0xe3, 0x81, 0x93, 0xe3, 0x82, 0x93, 0xe3, 0x81, 0xab, 0xe3, 0x81, 0xa1, 0xe3, 0x81, 0xaf}, `"こんにちは"`},
-		{"bin8", []byte{0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef}, `"0xdeadbeef"`},
-		{"fixstr len31", []byte{0xbf, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x30, 0x31}, `"1234567890123456789012345678901"`},
-		{"array16", []byte{0xdc, 0x00, 0x0f, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00}, "[0,1,0,1,0,1,0,1,0,1,0,1,0,1,0]"},
-		{"fixext1", []byte{0xd4, 0x01, 0xff}, "0xff"},
-		{"fixext2", []byte{0xd5, 0x01, 0xfe, 0xed}, "0xfeed"},
-		{"fixext4", []byte{0xd6, 0x01, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeef"},
-		{"fixext8", []byte{0xd7, 0x01, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeefdeadbeef"},
-		{"ext8", []byte{0xc7, 0x04, 0x01, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeef"},
-	}
-
-	/* str16 */
-	strcase := testcase{casename: "str16", expected: `"` + strings.Repeat("こんにちは", 20) + `"`}
-	strcase.msgpdata = []byte{0xda, 0x01, 0x2c}
-	for i := 0; i < 20; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, []byte{0xe3, 0x81, 0x93, 0xe3, 0x82, 0x93, 0xe3, 0x81, 0xab, 0xe3, 0x81, 0xa1, 0xe3, 0x81, 0xaf}...)
-	}
-	cases = append(cases, strcase)
-
-	/* str32 */
-	strcase = testcase{casename: "str32", expected: `"` + strings.Repeat("こんにちは", 4370) + `"`}
-	strcase.msgpdata = []byte{0xdb, 0x00, 0x01, 0x00, 0x0e}
-	for i := 0; i < 4370; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, []byte{0xe3, 0x81, 0x93, 0xe3, 0x82, 0x93, 0xe3, 0x81, 0xab, 0xe3, 0x81, 0xa1, 0xe3, 0x81, 0xaf}...)
-	}
-	cases = append(cases, strcase)
-
-	/* bin16 */
-	deadbeef := []byte{0xde, 0xad, 0xbe, 0xef}
-	strcase = testcase{casename: "bin16", expected: fmt.Sprintf(`"0x%x"`, bytes.Repeat(deadbeef, 64))}
-	strcase.msgpdata = []byte{0xc5, 0x01, 0x00}
-	for i := 0; i < 64; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, deadbeef...)
-	}
-	cases = append(cases, strcase)
-
-	/* bin32 */
-	strcase = testcase{casename: "bin32", expected: fmt.Sprintf(`"0x%x"`, bytes.Repeat(deadbeef, 16384))}
-	strcase.msgpdata = []byte{0xc6, 0x00, 0x01, 0x00, 0x00}
-	for i := 0; i < 16384; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, deadbeef...)
-	}
-	cases = append(cases, strcase)
-
-	/* ext16 */
-	strcase = testcase{casename: "ext16", expected: fmt.Sprintf("0x%x", bytes.Repeat(deadbeef, 64))}
-	strcase.msgpdata = []byte{0xc8, 0x01, 0x00, 0x01}
-	for i := 0; i < 64; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, deadbeef...)
-	}
-	cases = append(cases, strcase)
-
-	/* ext32 */
-	strcase = testcase{casename: "ext32", expected: fmt.Sprintf("0x%x", bytes.Repeat(deadbeef, 16384))}
-	strcase.msgpdata = []byte{0xc9, 0x00, 0x01, 0x00, 0x00, 0x01}
-	for i := 0; i < 16384; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, deadbeef...)
-	}
-	cases = append(cases, strcase)
-
-	/* array32 */
-	strcase = testcase{casename: "array32", expected: "[" + strings.Repeat("0,1,2,3,4,5,6,7,", 8191) + "0,1,2,3,4,5,6,7]"}
-	strcase.msgpdata = []byte{0xdd, 0x00, 0x01, 0x00, 0x00}
-	for i := 0; i < 8192; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}...)
-	}
-	cases = append(cases, strcase)
-
-	/* map16 */
-	strcase = testcase{casename: "map16", expected: "{" + strings.Repeat(`"0":0,"1":1,"2":2,"3":3,`, 3) + `"0":0,"1":1,"2":2,"3":3}`}
-	strcase.msgpdata = []byte{0xde, 0x00, 0x10}
-	for i := 0; i < 4; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, []byte{0xa1, 0x30, 0x00, 0xa1, 0x31, 0x01, 0xa1, 0x32, 0x02, 0xa1, 0x33, 0x03}...)
-	}
-	cases = append(cases, strcase)
-
-	/* map32 */
-	strcase = testcase{casename: "map32", expected: "{" + strings.Repeat(`"0":0,"1":1,"2":2,"3":3,`, 16383) + `"0":0,"1":1,"2":2,"3":3}`}
-	strcase.msgpdata = []byte{0xdf, 0x00, 0x01, 0x00, 0x00}
-	for i := 0; i < 16384; i++ {
-		strcase.msgpdata = append(strcase.msgpdata, []byte{0xa1, 0x30, 0x00, 0xa1, 0x31, 0x01, 0xa1, 0x32, 0x02, 0xa1, 0x33, 0x03}...)
-	}
-	cases = append(cases, strcase)
+		{"fixstr", msgpack.NewStr(special), special},
+		{"str 8", msgpack.NewStr(strings.Repeat(special, 4)), strings.Repeat(special, 4)},
+		{"str 16", msgpack.NewStr(strings.Repeat(special, 64)), strings.Repeat(special, 64)},
+		{"str 32", msgpack.NewStr(strings.Repeat(special, 16384)), strings.Repeat(special, 16384)},
+	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
-		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.msgpdata))
-		if err != nil {
-			t.Errorf("%s: Decode failed. Error: %s", v.casename, err)
-			continue
+		if v.obj.FormatName != v.casename {
+			t.Fatalf("%s: format is %s", v.casename, v.obj.FormatName)
 		}
-		outputJSON(ret, &buf, 0)
-
-		if buf.String() != v.expected {
-			t.Logf("%s: mismatch. given: %s. expected: %s", v.casename, buf.String(), v.expected)
+		enc := bytes.Buffer{}
+		if err := msgpack.Encode(&enc, v.obj); err != nil {
+			t.Fatalf("%s: Encode error %s", v.casename, err)
 		}
-		given := strings.Replace(buf.String(), " ", "", -1)
-		expected := strings.Replace(v.expected, " ", "", -1)
-
-		if given != expected {
-			t.Errorf("%s: mismatch. given: %s. expected: %s", v.casename, given, expected)
+		/* as a value and a key of map in raw and verbose mode */
+		inputs := [][]byte{enc.Bytes(), append(append([]byte{0x81}, enc.Bytes()...), 0xc0)}
+		for _, rawmode := range []bool{true, false} {
+			for i, in := range inputs {
+				buf.Reset()
+				decodeAndOutput(bytes.NewReader(in), &buf, "test", &config{rawmode: rawmode})
+				var ret string
+				switch {
+				case rawmode && i == 0:
+					err := json.Unmarshal(buf.Bytes(), &ret)
+					if err != nil {
+						t.Errorf("%s: Unmarshal error %s", v.casename, err)
+					}
+				case rawmode:
+					m := map[string]interface{}{}
+					if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+						t.Errorf("%s: key: Unmarshal error %s", v.casename, err)
+					}
+					for k := range m {
+						ret = k
+					}
+				case i == 0:
+					p := MPString{}
+					if err := json.Unmarshal(buf.Bytes(), &p); err != nil {
+						t.Errorf("%s: verbose: Unmarshal error %s", v.casename, err)
+					}
+					ret = p.Value
+				default:
+					if !json.Valid(buf.Bytes()) {
+						t.Errorf("%s: verbose key: invalid JSON", v.casename)
+					}
+					ret = v.expected
+				}
+				if ret != v.expected {
+					t.Errorf("%s: mismatch. rawmode=%v input=%d given: %q", v.casename, rawmode, i, ret)
+				}
+			}
 		}
 	}
 }
 
-type MPBase struct {
-	Format string `json:"format"`
-	Byte   string `json:"header"`
-	Raw    string `json:"raw"`
-}
-
-type MPString struct {
-	MPBase
-	Value string `json:"value"`
-}
-
-func TestVerboseJSONString(t *testing.T) {
+func TestOutputJSONNonStrKey(t *testing.T) {
 	type testcase struct {
 		casename string
-		bytes    []byte
+		msgpdata []byte
+		keys     string
+		ret      int
 		expected string
 	}
 
 	cases := []testcase{
-		{"fixstr", []byte{0xa2, 0x41, 0x42}, `AB`},
-		{"str8", []byte{0xd9, 0x0f, 0xe3, 0x81, 0x93, 0xe3, 0x82, 0x93, 0xe3, 0x81, 0xab, 0xe3, 0x81, 0xa1, 0xe3, 0x81, 0xaf}, `こんにちは`},
-		{"bin8", []byte{0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeef"},
+		{"int", []byte{0x81, 0x01, 0x02}, "", 0, `{"1":2}`},
+		{"nil", []byte{0x81, 0xc0, 0x02}, "string", 0, `{"null":2}`},
+		{"bin", []byte{0x81, 0xc4, 0x01, 0xff, 0x02}, "string", 0, `{"0xff":2}`},
+		{"array", []byte{0x81, 0x92, 0x01, 0xa1, 0x22, 0x02}, "string", 0, `{"[1,\"\\\"\"]":2}`},
+		{"map", []byte{0x81, 0x81, 0x01, 0x02, 0x03}, "string", 0, `{"{\"1\":2}":3}`},
+		{"str only", []byte{0x81, 0xa1, 0x41, 0x01}, "json", 0, `{"A":1}`},
+		{"json", []byte{0x82, 0xa1, 0x41, 0x01, 0xc4, 0x01, 0xff, 0x02}, "json", 0, `{"$json_keys":{"\"A\"":1,"\"0xff\"":2}}`},
+		{"json nested", []byte{0x91, 0x81, 0x92, 0x01, 0x02, 0x81, 0x03, 0x04}, "json", 0, `[{"$json_keys":{"[1,2]":{"$json_keys":{"3":4}}}}]`},
+		{"pairs", []byte{0x82, 0xa1, 0x41, 0x01, 0x92, 0x01, 0x02, 0x81, 0x03, 0x04}, "pairs", 0, `{"$pairs":[["A",1],[[1,2],{"$pairs":[[3,4]]}]]}`},
+		{"pairs str only", []byte{0x81, 0xa1, 0x41, 0x01}, "pairs", 0, `{"A":1}`},
+		{"nested key", []byte{0x81, 0x81, 0x91, 0x01, 0x02, 0x03}, "string", 0, `{"{\"$pairs\":[[[1],2]]}":3}`},
+		{"nested key json", []byte{0x81, 0x81, 0x91, 0x01, 0x02, 0x03}, "json", 0, `{"$json_keys":{"{\"$pairs\":[[[1],2]]}":3}}`},
+		{"error", []byte{0x91, 0x81, 0xa1, 0x41, 0x81, 0x01, 0x02}, "error", 1, ``},
+		{"error str only", []byte{0x81, 0xa1, 0x41, 0x01}, "error", 0, `{"A":1}`},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPString{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(v.msgpdata), &buf, "test", &config{rawmode: true, keys: v.keys})
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d, expect %d", v.casename, ret, v.ret)
+		}
+		if v.ret != 0 {
+			if buf.Len() != 0 {
+				t.Errorf("%s: output %s", v.casename, buf.String())
+			}
+			continue
+		}
+		if buf.String() != v.expected+"\n" {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, buf.String(), v.expected)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %s. expected: %s", v.casename, p.Value, v.expected)
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("%s: invalid JSON %s", v.casename, buf.String())
 		}
 	}
 }
 
-type MPExt struct {
-	MPBase
-	Type  int8   `json:"type"`
-	Value string `json:"value"`
+// TestRandomInputValidJSON checks that every decodable input is converted into valid JSON.
+func TestRandomInputValidJSON(t *testing.T) {
+	/* bytes which are likely to make strings to be escaped and nested objects */
+	interesting := []byte{0x00, 0x0a, 0x1f, 0x22, 0x5c, 0x7f, 0x81, 0x82, 0x91, 0x92, 0xa1, 0xa2, 0xa4, 0xc0, 0xc4, 0xc7, 0xca, 0xcb, 0xd4, 0xd6, 0xd7, 0xd9, 0xe3, 0xff}
+	cnfs := []*config{
+		{rawmode: true},
+		{rawmode: true, utf8: "escape", nonFinite: "string"},
+		{rawmode: true, utf8: "hex", keys: "json", binEnc: "tagged"},
+		{rawmode: true, keys: "pairs", int64: "long"},
+		{},
+		{utf8: "escape", nonFinite: "string", int64: "safe"},
+		{utf8: "hex"},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	buf := bytes.Buffer{}
+	for i := 0; i < 20000; i++ {
+		in := make([]byte, r.Intn(32)+1)
+		for j := range in {
+			if r.Intn(2) == 0 {
+				in[j] = interesting[r.Intn(len(interesting))]
+			} else {
+				in[j] = byte(r.Intn(256))
+			}
+		}
+		obj, err := msgpack.Decode(bytes.NewBuffer(in))
+		if err != nil {
+			continue
+		}
+		for _, cnf := range cnfs {
+			buf.Reset()
+			output(obj, &buf, nil, "test", cnf)
+			if !json.Valid(buf.Bytes()) {
+				t.Fatalf("input 0x%x: invalid JSON %s (rawmode %v, utf8 %q)", in, buf.String(), cnf.rawmode, cnf.utf8)
+			}
+		}
+	}
 }
 
-func TestVerboseJSONExt(t *testing.T) {
+func TestExtValue(t *testing.T) {
 	type testcase struct {
 		casename string
 		bytes    []byte
+		rawmode  bool
 		expected string
 	}
 
+	exts := msgpack.NewExtRegistry()
+	exts.Register(&msgpack.ExtFormat{FirstByte: msgpack.Ext8Format, ExtType: 1, TypeName: "nested", ValueFunc: func(b []byte) (interface{}, error) {
+		return msgpack.Decode(bytes.NewBuffer(b))
+	}})
+	exts.Register(&msgpack.ExtFormat{FirstByte: msgpack.FixExt1Format, ExtType: 2, TypeName: "flag", ValueFunc: func(b []byte) (interface{}, error) {
+		return b[0] != 0, nil
+	}})
+
 	cases := []testcase{
-		{"fixext1", []byte{0xd4, 0x01, 0xff}, "0xff"},
-		{"fixext2", []byte{0xd5, 0x01, 0xfe, 0xed}, "0xfeed"},
-		{"fixext4", []byte{0xd6, 0x01, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeef"},
-		{"fixext8", []byte{0xd7, 0x01, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef}, "0xdeadbeefdeadbeef"},
+		{"timestamp raw", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, true, `{"sec":1,"nsec":0}`},
+		{"timestamp", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, false, `{"format":"timestamp 32", "header":"0xd6", "offset":0, "size":6, "type":-1, "raw":"0xd6ff00000001", "decoded":{"sec":1,"nsec":0}, "value":"` + time.Unix(1, 0).String() + `"}`},
+		{"timestamp error", []byte{0xd7, 0xff, 0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x01}, false,
+			`{"format":"timestamp 64", "header":"0xd7", "offset":0, "size":10, "type":-1, "raw":"0xd7ffee6b280000000001", "ext_error":"nanoseconds 1000000000 is out of range 0 to 999999999", "value":"0xee6b280000000001"}`},
+		{"nested raw", []byte{0xc7, 0x03, 0x01, 0x92, 0x01, 0xc3}, true, `[1,true]`},
+		{"bool raw", []byte{0xd4, 0x02, 0x01}, true, `true`},
+		{"not decoded raw", []byte{0xd4, 0x03, 0x01}, true, `"0x01"`},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPExt{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(v.bytes), &buf, "test", &config{rawmode: v.rawmode, exts: exts})
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %s. expected: %s", v.casename, p.Value, v.expected)
+		if buf.String() != v.expected+"\n" {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
 		}
 	}
 }
 
-type MPBool struct {
-	MPBase
-	Value bool `json:"value"`
-}
-
-func TestVerboseJSONBool(t *testing.T) {
+func TestTimeFormatOption(t *testing.T) {
 	type testcase struct {
 		casename string
-		bytes    []byte
-		expected bool
+		zone     string
+		layout   string
+		rawmode  bool
+		expected string
 	}
 
+	/* timestamp 64: 2019-05-14T00:00:00.5Z */
+	b := []byte{0xd7, 0xff, 0x77, 0x35, 0x94, 0x00, 0x5c, 0xda, 0x05, 0x00}
 	cases := []testcase{
-		{"true", []byte{0xc3}, true},
-		{"false", []byte{0xc2}, false},
+		{"rfc3339", "UTC", "rfc3339", true, `"2019-05-14T00:00:00Z"`},
+		{"rfc3339nano", "Asia/Tokyo", "rfc3339nano", true, `"2019-05-14T09:00:00.5+09:00"`},
+		{"unix", "UTC", "unix", true, `1557792000.5`},
+		{"unixnano", "UTC", "unixnano", true, `1557792000500000000`},
+		{"custom", "UTC", "2006/01/02 15:04:05.0", true, `"2019/05/14 00:00:00.5"`},
+		{"zone only", "UTC", "", true, `"2019-05-14 00:00:00.5 +0000 UTC"`},
+		{"verbose", "UTC", "unix", false, `{"format":"timestamp 64", "header":"0xd7", "offset":0, "size":10, "type":-1, "raw":"0xd7ff773594005cda0500", "decoded":{"sec":1557792000,"nsec":500000000}, "value":"1557792000.5"}`},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPBool{}
-		err = json.Unmarshal(buf.Bytes(), &p)
+		f, err := timeFormat(v.zone, v.layout)
 		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+			t.Fatalf("%s: err=%v", v.casename, err)
+		}
+		ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{rawmode: v.rawmode, times: f})
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %t. expected: %t", v.casename, p.Value, v.expected)
+		if buf.String() != v.expected+"\n" {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
 		}
 	}
-}
 
-type MPNil struct {
-	MPBase
-	Value *bool `json:"value"`
+	if _, err := timeFormat("Nowhere/Unknown", ""); err == nil {
+		t.Errorf("unknown zone is not detected")
+	}
 }
 
-func TestVerboseJSONNil(t *testing.T) {
-	b := []byte{0xc0}
-	buf := bytes.Buffer{}
-
-	ret, err := msgpack.Decode(bytes.NewBuffer(b))
-	outputVerboseJSON(ret, &buf, 0)
+func TestNonFinite(t *testing.T) {
+	type testcase struct {
+		casename  string
+		bytes     []byte
+		nonFinite string
+		rawmode   bool
+		ret       int
+		expected  string
+	}
 
-	p := MPNil{}
-	err = json.Unmarshal(buf.Bytes(), &p)
-	if err != nil {
-		t.Errorf("Nil: Unmarshal Error %s", err)
+	nan := []byte{0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	inf := []byte{0xca, 0xff, 0x80, 0x00, 0x00}
+	cases := []testcase{
+		{"null", nan, "null", true, 0, "null\n"},
+		{"string", inf, "string", true, 0, "\"-Inf\"\n"},
+		{"error", append([]byte{0x91}, nan...), "error", true, 1, ""},
+		{"error key", append([]byte{0x81}, append(inf, 0xc0)...), "error", true, 1, ""},
+		{"error finite", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, "error", true, 0, "1.5\n"},
+		{"verbose nan", nan, "null", false, 0, `{"format":"float 64", "header":"0xcb", "offset":0, "size":9, "raw":"0xcb7ff8000000000001", "bits":"0x7ff8000000000001", "value":null}` + "\n"},
+		{"verbose inf", inf, "string", false, 0, `{"format":"float 32", "header":"0xca", "offset":0, "size":5, "raw":"0xcaff800000", "bits":"0xff800000", "value":"-Inf"}` + "\n"},
 	}
-	if p.Value != nil {
-		t.Errorf("Nil: Value is not nil")
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		ret := decodeAndOutput(bytes.NewReader(v.bytes), &buf, "test", &config{rawmode: v.rawmode, nonFinite: v.nonFinite})
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d, expect %d", v.casename, ret, v.ret)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
+		}
 	}
-}
 
-type MPInt struct {
-	MPBase
-	Value int64 `json:"value"`
+	/* the source is not shown for the object which can not be converted */
+	buf.Reset()
+	b := append(append([]byte{0x91}, nan...), 0x01)
+	if ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{rawmode: true, nonFinite: "error", showSource: true}); ret != 1 {
+		t.Errorf("show source: decodeAndOutput returns %d", ret)
+	}
+	if buf.String() != "test: 1\n" {
+		t.Errorf("show source: mismatch. given: %q", buf.String())
+	}
 }
 
-func TestVerboseJSONInt(t *testing.T) {
+func TestInt64Option(t *testing.T) {
 	type testcase struct {
 		casename string
 		bytes    []byte
-		expected int64
+		int64    string
+		rawmode  bool
+		expected string
 	}
 
+	/* 2^53+1, which float64 can not represent */
+	unsafe := []byte{0xcf, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	/* 2^53 as int 64, which float64 can represent */
+	safe64 := []byte{0xd3, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	/* -(2^53-1) as int 64 */
+	minSafe := []byte{0xd3, 0xff, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	u32 := []byte{0xce, 0xff, 0xff, 0xff, 0xff}
 	cases := []testcase{
-		{"p fixint", []byte{0x01}, 1},
-		{"n fixint", []byte{0xff}, -1},
-		{"int8", []byte{0xd0, 0xff}, -1},
-		{"int16", []byte{0xd1, 0xff, 0x00}, -256},
-		{"int32", []byte{0xd2, 0xff, 0x00, 0xff, 0x00}, -16711936},
-		{"int64", []byte{0xd3, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, -71777214294589696},
+		{"number", unsafe, "number", true, `9007199254740993`},
+		{"default", unsafe, "", true, `9007199254740993`},
+		{"safe unsafe", unsafe, "safe", true, `"9007199254740993"`},
+		{"safe 2^53", safe64, "safe", true, `"9007199254740992"`},
+		{"safe min", minSafe, "safe", true, `-9007199254740991`},
+		{"safe uint32", u32, "safe", true, `4294967295`},
+		{"string", unsafe, "string", true, `"9007199254740993"`},
+		{"string min", minSafe, "string", true, `"-9007199254740991"`},
+		{"string uint32", u32, "string", true, `4294967295`},
+		{"long", unsafe, "long", true, `{"$numberLong":"9007199254740993"}`},
+		{"long uint32", u32, "long", true, `4294967295`},
+		{"nested", []byte{0x91, 0xd3, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, "long", true, `[{"$numberLong":"9007199254740993"}]`},
+		{"verbose lost", unsafe, "number", false, `{"format":"uint 64", "header":"0xcf", "offset":0, "size":9, "raw":"0xcf0020000000000001", "precision_lost":true, "value":9007199254740993}`},
+		{"verbose exact", safe64, "number", false, `{"format":"int 64", "header":"0xd3", "offset":0, "size":9, "raw":"0xd30020000000000000", "value":9007199254740992}`},
+		{"verbose quoted", unsafe, "safe", false, `{"format":"uint 64", "header":"0xcf", "offset":0, "size":9, "raw":"0xcf0020000000000001", "value":"9007199254740993"}`},
+		{"verbose long", unsafe, "long", false, `{"format":"uint 64", "header":"0xcf", "offset":0, "size":9, "raw":"0xcf0020000000000001", "value":{"$numberLong":"9007199254740993"}}`},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPInt{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(v.bytes), &buf, "test", &config{rawmode: v.rawmode, int64: v.int64})
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %d. expected: %d", v.casename, p.Value, v.expected)
+		if buf.String() != v.expected+"\n" {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
 		}
 	}
 }
 
-type MPUint struct {
-	MPBase
-	Value uint64 `json:"value"`
-}
-
-func TestVerboseJSONUint(t *testing.T) {
+func TestBinEncoding(t *testing.T) {
 	type testcase struct {
 		casename string
 		bytes    []byte
-		expected uint64
+		binEnc   string
+		expected string
 	}
 
+	bin := []byte{0xc4, 0x04, 0xde, 0xad, 0xbe, 0xef}
+	ext := []byte{0xd6, 0x01, 0xde, 0xad, 0xbe, 0xef}
 	cases := []testcase{
-		{"uint8", []byte{0xcc, 0xff}, 255},
-		{"uint16", []byte{0xcd, 0xff, 0x00}, 65280},
-		{"uint32", []byte{0xce, 0xff, 0x00, 0xff, 0x00}, 4278255360},
-		{"uint64", []byte{0xcf, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, 18374966859414961920},
+		{"default", bin, "", `"0xdeadbeef"`},
+		{"hex", bin, "hex", `"0xdeadbeef"`},
+		{"base64", bin, "base64", `"3q2+7w=="`},
+		{"base64url", bin, "base64url", `"3q2-7w=="`},
+		{"tagged", bin, "tagged", `{"$bin":"3q2+7w==","encoding":"base64"}`},
+		{"ext hex", ext, "hex", `"0xdeadbeef"`},
+		{"ext base64", ext, "base64", `"3q2+7w=="`},
+		{"ext tagged", ext, "tagged", `{"$ext":1,"data":"3q2+7w=="}`},
+		{"ext negative tagged", []byte{0xd4, 0xfe, 0xff}, "tagged", `{"$ext":-2,"data":"/w=="}`},
+		{"timestamp", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, "tagged", `{"sec":1,"nsec":0}`},
+		{"broken timestamp", []byte{0xd4, 0xff, 0x01}, "tagged", `{"$ext":-1,"data":"AQ=="}`},
+		{"key", append([]byte{0x81}, append(bin, 0xc0)...), "base64", `{"3q2+7w==":null}`},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPUint{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(v.bytes), &buf, "test", &config{rawmode: true, binEnc: v.binEnc})
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %d. expected: %d", v.casename, p.Value, v.expected)
+		if buf.String() != v.expected+"\n" {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
 		}
 	}
+
+	/* DecodeFunc shows the payload as a string */
+	exts := msgpack.NewExtRegistry()
+	exts.Register(&msgpack.ExtFormat{FirstByte: 0xd6, ExtType: 1, TypeName: "ipv4", DecodeFunc: func(b []byte) string {
+		return fmt.Sprintf("%d.%d.%d.%d", b[0], b[1], b[2], b[3])
+	}})
+	buf.Reset()
+	decodeAndOutput(bytes.NewReader(ext), &buf, "test", &config{rawmode: true, binEnc: "tagged", exts: exts})
+	if buf.String() != "\"222.173.190.239\"\n" {
+		t.Errorf("DecodeFunc: mismatch. given: %s", buf.String())
+	}
 }
 
-type MPFloat struct {
-	MPBase
-	Value float64 `json:"value"`
+func TestDecodeAndOutput(t *testing.T) {
+	/* 1, [0,1], "AB" */
+	b := []byte{0x01, 0x92, 0x00, 0x01, 0xa2, 0x41, 0x42}
+	expected := "1\n[0,1]\n\"AB\"\n"
+
+	buf := bytes.Buffer{}
+	ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{rawmode: true})
+	if ret != 0 {
+		t.Errorf("decodeAndOutput returns %d", ret)
+	}
+	if buf.String() != expected {
+		t.Errorf("mismatch. given: %q. expected: %q", buf.String(), expected)
+	}
 }
 
-func TestVerboseJSONFloat(t *testing.T) {
+func TestDecodeAndOutputLint(t *testing.T) {
 	type testcase struct {
 		casename string
-		bytes    []byte
-		expected float64
+		msgpdata []byte
+		ret      int
+		expected string
 	}
 
 	cases := []testcase{
-		{"float32", []byte{0xca, 0x80, 0x00, 0x00, 0x00}, -0.000000},
-		{"float64", []byte{0xcb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, -0.000000},
+		{"no finding", []byte{0x81, 0xa1, 0x41, 0x01}, 0, ""},
+		{"warning", []byte{0x01, 0x81, 0xa1, 0x41, 0xcc, 0x01}, 0, "test: warning: offset 4, path $.A, format uint 8: 1 can be encoded as positive fixint\n"},
+		{"error", []byte{0xd7, 0xff, 0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x01}, 1, "test: error: offset 0, path $, format timestamp 64: nanoseconds 1000000000 is larger than 999999999\n"},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPFloat{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(v.msgpdata), &buf, "test", &config{lint: true, showSource: true})
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if v.expected != p.Value {
-			t.Errorf("%s: mismatch. given: %f. expected: %f", v.casename, p.Value, v.expected)
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %q. expected: %q", v.casename, buf.String(), v.expected)
 		}
 	}
 }
 
-type MPArray struct {
-	MPBase
-	Value []MPInt `json:"value"`
+func TestDecodeAndOutputQuery(t *testing.T) {
+	type testcase struct {
+		casename string
+		query    string
+		rawmode  bool
+		expected string
+	}
+
+	/* ["tag",[[1,{"log":"a"}],[2,{"log":"b"}]]] {"log":"c"} */
+	b := []byte{0x92, 0xa3, 0x74, 0x61, 0x67, 0x92, 0x92, 0x01, 0x81, 0xa3, 0x6c, 0x6f, 0x67, 0xa1, 0x61, 0x92, 0x02, 0x81, 0xa3, 0x6c, 0x6f, 0x67, 0xa1, 0x62,
+		0x81, 0xa3, 0x6c, 0x6f, 0x67, 0xa1, 0x63}
+	cases := []testcase{
+		{"raw", "$[1][*][1].log", true, "\"a\"\n\"b\"\n"},
+		{"recursive", "$..log", true, "\"a\"\n\"b\"\n\"c\"\n"},
+		{"container", "$[1][1]", true, "[2,{\"log\":\"b\"}]\n"},
+		{"no match", "$[2]", true, ""},
+		{"verbose", "$[1][0][0]", false, `{"format":"positive fixint", "header":"0x01", "offset":7, "size":1, "raw":"0x01", "value":1}` + "\n"},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		sel, err := msgpack.CompileQuery(v.query)
+		if err != nil {
+			t.Fatalf("%s: err=%v", v.casename, err)
+		}
+		ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{rawmode: v.rawmode, query: sel})
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %q. expected: %q", v.casename, buf.String(), v.expected)
+		}
+	}
 }
 
-func TestVerboseJSONArray(t *testing.T) {
+func TestDecodeAndOutputInspect(t *testing.T) {
 	type testcase struct {
 		casename string
-		bytes    []byte
-		length   int
+		msgpdata []byte
+		format   string
+		query    string
+		expected string
 	}
 
+	/* 1, [true] */
+	b := []byte{0x01, 0x91, 0xc3}
 	cases := []testcase{
-		{"fixarray len2", []byte{0x92, 0x00, 0x01}, 2},
-		{"array16", []byte{0xdc, 0x00, 0x0f, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00}, 15},
+		{"json", b, "inspect-json", "", `[
+  {
+    "format": "fixint",
+    "header": "0x01",
+    "data": "0x01",
+    "value": 1
+  },
+  {
+    "format": "fixarray",
+    "header": "0x91",
+    "length": 1,
+    "children": [
+      {
+        "format": "true",
+        "header": "0xc3",
+        "data": "0xc3",
+        "value": true
+      }
+    ]
+  }
+]
+`},
+		{"yaml", b, "inspect-yaml", "", `---
+- format: "fixint"
+  header: "0x01"
+  data: "0x01"
+  value: 1
+- format: "fixarray"
+  header: "0x91"
+  length: 1
+  children:
+    - format: "true"
+      header: "0xc3"
+      data: "0xc3"
+      value: true
+`},
+		{"query", b, "inspect-yaml", "$[0]", "---\n- format: \"true\"\n  header: \"0xc3\"\n  data: \"0xc3\"\n  value: true\n"},
+		{"empty json", []byte{}, "inspect-json", "", ""},
+		{"empty yaml", []byte{}, "inspect-yaml", "", ""},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		cnf := &config{format: v.format}
+		if v.query != "" {
+			sel, err := msgpack.CompileQuery(v.query)
+			if err != nil {
+				t.Fatalf("%s: err=%v", v.casename, err)
+			}
+			cnf.query = sel
+		}
+		ret := decodeAndOutput(bytes.NewReader(v.msgpdata), &buf, "test", cnf)
+		if ret != 0 {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, buf.String(), v.expected)
+		}
+	}
+
+	/* the array is closed even if the rest of the input is broken */
+	buf.Reset()
+	if ret := decodeAndOutput(bytes.NewReader([]byte{0x01, 0xd9}), &buf, "test", &config{format: "inspect-json"}); ret != 1 {
+		t.Errorf("broken: decodeAndOutput returns %d", ret)
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Errorf("broken: invalid JSON %s", buf.String())
 	}
 
-	zb := bytes.Buffer{}
-	z, err := msgpack.Decode(bytes.NewBuffer([]byte{0x00}))
-	outputVerboseJSON(z, &zb, 0)
-	zero := MPInt{}
-	err = json.Unmarshal(zb.Bytes(), &zero)
-	if err != nil {
-		t.Errorf("json.Unmarshal Error")
+	/* nothing is written for the object which can not be converted */
+	nan := []byte{0xca, 0x7f, 0xc0, 0x00, 0x00}
+	rest := []byte{0x01, 0x91, 0x02}
+	for _, format := range []string{"inspect-json", "inspect-yaml", "json"} {
+		cnf := &config{format: format, rawmode: true, nonFinite: "error", showSource: format == "json"}
+		expected := bytes.Buffer{}
+		decodeAndOutput(bytes.NewReader(rest), &expected, "test", cnf)
+		buf.Reset()
+		if ret := decodeAndOutput(bytes.NewReader(append(append([]byte{0x91}, nan...), rest...)), &buf, "test", cnf); ret != 1 {
+			t.Errorf("%s: decodeAndOutput returns %d", format, ret)
+		}
+		if buf.String() != expected.String() {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", format, buf.String(), expected.String())
+		}
+	}
+	if buf.String() != "test: 1\ntest: [2]\n" {
+		t.Errorf("json: mismatch. given: %q", buf.String())
 	}
+}
 
-	ob := bytes.Buffer{}
-	o, err := msgpack.Decode(bytes.NewBuffer([]byte{0x01}))
-	outputVerboseJSON(o, &ob, 0)
-	one := MPInt{}
-	err = json.Unmarshal(ob.Bytes(), &one)
-	if err != nil {
-		t.Errorf("json.Unmarshal Error")
+func TestInvalidUTF8(t *testing.T) {
+	type testcase struct {
+		casename string
+		cnf      *config
+		ret      int
+		expected string
+	}
+
+	/* ["A\xffB", "C"] */
+	b := []byte{0x92, 0xa3, 0x41, 0xff, 0x42, 0xa1, 0x43}
+	cases := []testcase{
+		{"default", &config{rawmode: true}, 0, "[\"A\ufffdB\",\"C\"]\n"},
+		{"replace", &config{rawmode: true, utf8: "replace"}, 0, "[\"A\ufffdB\",\"C\"]\n"},
+		{"escape", &config{rawmode: true, utf8: "escape"}, 0, "[\"A\\ufffdB\",\"C\"]\n"},
+		{"hex", &config{rawmode: true, utf8: "hex"}, 0, "[\"0x41ff42\",\"C\"]\n"},
+		{"strict", &config{rawmode: true, strict: true}, 1, "\n"}, /* the broken array is not output */
+		{"lint", &config{lint: true}, 1, "error: offset 1, path $[0], format fixstr: invalid UTF-8 at offset 3 (payload index 1)\n"},
 	}
 
 	buf := bytes.Buffer{}
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPArray{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", v.cnf)
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if len(p.Value) != v.length {
-			t.Errorf("%s: Length Error given: %d. expected: %d", v.casename, len(p.Value), v.length)
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %q. expected: %q", v.casename, buf.String(), v.expected)
 		}
-		for i, c := range p.Value {
-			if i%2 == 0 && c != zero {
-				t.Errorf("%s:mismatch given: %v. expected: %v", v.casename, c, zero)
-			} else if i%2 != 0 && c != one {
-				t.Errorf("%s:mismatch given: %v. expected: %v", v.casename, c, one)
-			}
+		if !v.cnf.lint && !v.cnf.strict && !json.Valid(buf.Bytes()) {
+			t.Errorf("%s: invalid JSON %q", v.casename, buf.String())
 		}
 	}
+
+	/* verbose */
+	buf.Reset()
+	decodeAndOutput(bytes.NewReader(b[1:5]), &buf, "test", &config{utf8: "hex"})
+	p := struct {
+		InvalidUTF8 int64  `json:"invalid_utf8"`
+		Value       string `json:"value"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &p); err != nil {
+		t.Fatalf("verbose: json.Unmarshal failed. %v", err)
+	}
+	if p.InvalidUTF8 != 2 || p.Value != "0x41ff42" {
+		t.Errorf("verbose: mismatch. given: %+v", p)
+	}
 }
 
-type MPMap struct {
-	MPBase
-	Value []map[string]interface{} `json:"value"`
+func TestDecodeAndOutputLimits(t *testing.T) {
+	type testcase struct {
+		casename string
+		msgpdata []byte
+		limits   msgpack.Limits
+		ret      int
+	}
+
+	cases := []testcase{
+		{"array32 header", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, msgpack.Limits{MaxLength: 1024}, 1},
+		{"str32 header", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, msgpack.Limits{MaxSize: 1024}, 1},
+		{"depth", []byte{0x91, 0x91, 0x01}, msgpack.Limits{MaxDepth: 1}, 1},
+		{"bytes", []byte{0x01, 0x02}, msgpack.Limits{MaxBytes: 1}, 1},
+		{"within limits", []byte{0x91, 0x91, 0x01}, msgpack.Limits{MaxDepth: 2, MaxLength: 1, MaxSize: 1, MaxBytes: 3}, 0},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		ret := decodeAndOutput(bytes.NewReader(v.msgpdata), &buf, "test", &config{rawmode: true, limits: v.limits})
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
+		}
+	}
 }
 
-/*
-type MPKey struct {
-	MPBase
-	Value MPString `json:key`
+func TestOutputDeepNesting(t *testing.T) {
+	/* [[[...[1]...]]] */
+	depth := 100000
+	b := append(bytes.Repeat([]byte{0x91}, depth), 0x01)
+	expected := strings.Repeat("[", depth) + "1" + strings.Repeat("]", depth) + "\n"
+
+	buf := bytes.Buffer{}
+	if ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{rawmode: true}); ret != 0 {
+		t.Errorf("decodeAndOutput returns %d", ret)
+	}
+	if buf.String() != expected {
+		t.Errorf("raw: mismatch")
+	}
+
+	/* verbose output is indented by depth */
+	depth = 1000
+	b = append(bytes.Repeat([]byte{0x81, 0xa1, 0x6b}, depth), 0x01)
+	buf.Reset()
+	if ret := decodeAndOutput(bytes.NewReader(b), &buf, "test", &config{}); ret != 0 {
+		t.Errorf("decodeAndOutput returns %d", ret)
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Errorf("verbose: invalid JSON. %v", err)
+	}
 }
 
-type MPValue struct {
-	MPBase
-	Value MPInt `json:value`
+func TestPrintDecodeError(t *testing.T) {
+	b := []byte{0x92, 0x01, 0x81, 0xa4, 0x74, 0x61, 0x67, 0x73, 0xd9, 0x03}
+	expected := []string{"Error(truncated data)", "offset: 10 (0xa)", "path:   $[1].tags", "format: str 8", "needed: 3 bytes, available: 0 bytes"}
+
+	_, err := msgpack.Decode(bytes.NewBuffer(b))
+	if err == nil {
+		t.Fatalf("No error is detected")
+	}
+	buf := bytes.Buffer{}
+	printDecodeError(&buf, err)
+	for _, v := range expected {
+		if !strings.Contains(buf.String(), v) {
+			t.Errorf("%q is not printed. given: %s", v, buf.String())
+		}
+	}
 }
-*/
 
-func TestVerboseJSONMap(t *testing.T) {
+func TestNeverUsed(t *testing.T) {
 	type testcase struct {
 		casename string
-		bytes    []byte
-		length   int
+		cnf      *config
+		ret      int
+		expected string
 	}
 
+	/* [1, 0xc1, 2] */
+	b := []byte{0x93, 0x01, 0xc1, 0x02}
 	cases := []testcase{
-		{"fixmap len2", []byte{0x82, 0xa1, 0x30, 0x00, 0xa1, 0x31, 0x01}, 2},
+		{"default", &config{rawmode: true}, 0, "[1,null,2]\n"},
+		{"strict", &config{rawmode: true, strict: true}, 1, "\n"}, /* the broken array is not output */
 	}
 
+	/* lint reports all of 0xc1 and the following objects */
 	buf := bytes.Buffer{}
+	ret := decodeAndOutput(bytes.NewReader([]byte{0x93, 0xc1, 0xc1, 0xcd, 0x00, 0x01}), &buf, "test", &config{lint: true})
+	expected := `error: offset 1, path $[0], format (never used): 0xc1 is never used
+error: offset 2, path $[1], format (never used): 0xc1 is never used
+warning: offset 3, path $[2], format uint 16: 1 can be encoded as positive fixint
+`
+	if ret != 1 || buf.String() != expected {
+		t.Errorf("lint: mismatch. ret=%d given: %q. expected: %q", ret, buf.String(), expected)
+	}
+
 	for _, v := range cases {
 		buf.Reset()
-		ret, err := msgpack.Decode(bytes.NewBuffer(v.bytes))
-		outputVerboseJSON(ret, &buf, 0)
-
-		p := MPMap{}
-		err = json.Unmarshal(buf.Bytes(), &p)
-		if err != nil {
-			t.Errorf("%s: Unmarshal Error %s", v.casename, err)
+		ret = decodeAndOutput(bytes.NewReader(b), &buf, "test", v.cnf)
+		if ret != v.ret {
+			t.Errorf("%s: decodeAndOutput returns %d", v.casename, ret)
 		}
-		if len(p.Value) != v.length {
-			t.Errorf("%s: Length Error given: %d. expected: %d", v.casename, len(p.Value), v.length)
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %q. expected: %q", v.casename, buf.String(), v.expected)
 		}
-		// TODO: check p.Value
 	}
 }
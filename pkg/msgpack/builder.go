@@ -0,0 +1,247 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"math"
+	"time"
+)
+
+/* Functions to create MPObject with the smallest format for the value. */
+
+// formatName returns the format name of the first byte b.
+func formatName(b byte) string {
+	switch {
+	case isPositiveFixInt(b):
+		return "positive fixint"
+	case isNegativeFixInt(b):
+		return "negative fixint"
+	case isFixMap(b):
+		return "fixmap"
+	case isFixArray(b):
+		return "fixarray"
+	case isFixStr(b):
+		return "fixstr"
+	}
+	return typeStr(b)
+}
+
+func newObject(b byte, v interface{}) *MPObject {
+	obj := &MPObject{FirstByte: b, FormatName: formatName(b), HeaderSize: headerSize(b)}
+	obj.SetValue(v)
+	return obj
+}
+
+// NewNil returns nil object.
+func NewNil() *MPObject {
+	return newObject(NilFormat, nil)
+}
+
+// NewBool returns true or false object.
+func NewBool(v bool) *MPObject {
+	if v {
+		return newObject(TrueFormat, v)
+	}
+	return newObject(FalseFormat, v)
+}
+
+// NewInt returns an object of int family or fixint.
+// Positive value is also encoded as int family unless it is positive fixint.
+func NewInt(v int64) *MPObject {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return newObject(byte(v), v)
+	case v >= -32 && v < 0:
+		return newObject(byte(int8(v)), v)
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return newObject(Int8Format, v)
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return newObject(Int16Format, v)
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return newObject(Int32Format, v)
+	}
+	return newObject(Int64Format, v)
+}
+
+// NewUint returns an object of uint family or positive fixint.
+func NewUint(v uint64) *MPObject {
+	switch {
+	case v <= 0x7f:
+		return newObject(byte(v), int64(v))
+	case v <= math.MaxUint8:
+		return newObject(Uint8Format, v)
+	case v <= math.MaxUint16:
+		return newObject(Uint16Format, v)
+	case v <= math.MaxUint32:
+		return newObject(Uint32Format, v)
+	}
+	return newObject(Uint64Format, v)
+}
+
+// NewInteger returns the smallest object for v.
+// Non-negative value is encoded as uint family.
+func NewInteger(v int64) *MPObject {
+	if v >= 0 {
+		return NewUint(uint64(v))
+	}
+	return NewInt(v)
+}
+
+// NewFloat32 returns float 32 object.
+func NewFloat32(v float32) *MPObject {
+	return newObject(Float32Format, v)
+}
+
+// NewFloat64 returns float 64 object.
+func NewFloat64(v float64) *MPObject {
+	return newObject(Float64Format, v)
+}
+
+// NewFloat returns float 32 object if v is exactly representable as float32.
+// Otherwise it returns float 64 object.
+func NewFloat(v float64) *MPObject {
+	if float64(float32(v)) == v {
+		return NewFloat32(float32(v))
+	}
+	return NewFloat64(v)
+}
+
+// NewStr returns an object of str family.
+func NewStr(v string) *MPObject {
+	var obj *MPObject
+	switch l := len(v); {
+	case l <= 0x1f:
+		obj = newObject(0xa0|byte(l), v)
+	case l <= math.MaxUint8:
+		obj = newObject(Str8Format, v)
+	case l <= math.MaxUint16:
+		obj = newObject(Str16Format, v)
+	default:
+		obj = newObject(Str32Format, v)
+	}
+	obj.Length = uint32(len(v))
+	return obj
+}
+
+// NewBin returns an object of bin family.
+func NewBin(v []byte) *MPObject {
+	var obj *MPObject
+	switch l := len(v); {
+	case l <= math.MaxUint8:
+		obj = newObject(Bin8Format, v)
+	case l <= math.MaxUint16:
+		obj = newObject(Bin16Format, v)
+	default:
+		obj = newObject(Bin32Format, v)
+	}
+	obj.Length = uint32(len(v))
+	return obj
+}
+
+// NewArray returns an object of array family which has children.
+func NewArray(children []*MPObject) *MPObject {
+	var b byte
+	switch l := len(children); {
+	case l <= 0xf:
+		b = 0x90 | byte(l)
+	case l <= math.MaxUint16:
+		b = Array16Format
+	default:
+		b = Array32Format
+	}
+	obj := newObject(b, nil)
+	obj.DataStr = "(" + obj.FormatName + ")"
+	obj.Length = uint32(len(children))
+	obj.Child = children
+	return obj
+}
+
+// NewMap returns an object of map family.
+// kv is a list of key and value pairs, i.e. kv[i*2] is a key and kv[i*2+1] is its value.
+func NewMap(kv []*MPObject) *MPObject {
+	var b byte
+	switch l := len(kv) / 2; {
+	case l <= 0xf:
+		b = 0x80 | byte(l)
+	case l <= math.MaxUint16:
+		b = Map16Format
+	default:
+		b = Map32Format
+	}
+	obj := newObject(b, nil)
+	obj.DataStr = "(" + obj.FormatName + ")"
+	obj.Length = uint32(len(kv) / 2)
+	obj.Child = kv
+	return obj
+}
+
+// NewExt returns an object of ext family.
+func NewExt(extType int8, data []byte) *MPObject {
+	var b byte
+	switch l := len(data); {
+	case l == 1:
+		b = FixExt1Format
+	case l == 2:
+		b = FixExt2Format
+	case l == 4:
+		b = FixExt4Format
+	case l == 8:
+		b = FixExt8Format
+	case l == 16:
+		b = FixExt16Format
+	case l <= math.MaxUint8:
+		b = Ext8Format
+	case l <= math.MaxUint16:
+		b = Ext16Format
+	default:
+		b = Ext32Format
+	}
+	obj := newObject(b, Ext{Type: extType, Data: data})
+	obj.ExtType = extType
+	if isExt(b) {
+		obj.Length = uint32(len(data))
+	}
+	return obj
+}
+
+// NewTimestamp returns timestamp ext object.
+// It uses timestamp 32, 64 or 96 which is the smallest for t.
+func NewTimestamp(t time.Time) *MPObject {
+	var obj *MPObject
+	switch sec := t.Unix(); {
+	case sec >= 0 && sec <= math.MaxUint32 && t.Nanosecond() == 0:
+		obj = newObject(FixExt4Format, t)
+		obj.FormatName = "timestamp 32"
+	case sec >= 0 && sec < 1<<34:
+		obj = newObject(FixExt8Format, t)
+		obj.FormatName = "timestamp 64"
+	default:
+		obj = newObject(Ext8Format, t)
+		obj.FormatName = "timestamp 96"
+		obj.Length = 12
+	}
+	obj.ExtType = -1
+	return obj
+}
+
+// NewEventTime returns Fluentd event time ext object.
+func NewEventTime(t time.Time) *MPObject {
+	obj := newObject(FixExt8Format, t)
+	obj.FormatName = "event time"
+	obj.ExtType = 0
+	return obj
+}
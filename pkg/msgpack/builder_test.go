@@ -0,0 +1,90 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *MPObject
+		expected []byte
+	}
+
+	cases := []testcase{
+		{"nil", NewNil(), []byte{0xc0}},
+		{"true", NewBool(true), []byte{0xc3}},
+		{"false", NewBool(false), []byte{0xc2}},
+		{"int p fixint", NewInt(1), []byte{0x01}},
+		{"int n fixint", NewInt(-32), []byte{0xe0}},
+		{"int8", NewInt(-33), []byte{0xd0, 0xdf}},
+		{"int16 positive", NewInt(0x80), []byte{0xd1, 0x00, 0x80}},
+		{"int16", NewInt(-0x8000), []byte{0xd1, 0x80, 0x00}},
+		{"int32", NewInt(0x10000), []byte{0xd2, 0x00, 0x01, 0x00, 0x00}},
+		{"int64", NewInt(math.MinInt64), []byte{0xd3, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"uint p fixint", NewUint(0x7f), []byte{0x7f}},
+		{"uint8", NewUint(0x80), []byte{0xcc, 0x80}},
+		{"uint16", NewUint(0x100), []byte{0xcd, 0x01, 0x00}},
+		{"uint32", NewUint(0x10000), []byte{0xce, 0x00, 0x01, 0x00, 0x00}},
+		{"uint64", NewUint(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"integer positive", NewInteger(0xff), []byte{0xcc, 0xff}},
+		{"integer negative", NewInteger(-1), []byte{0xff}},
+		{"float32", NewFloat(1.5), []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float64", NewFloat(0.1), []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"float64 forced", NewFloat64(1.5), []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"fixstr", NewStr("AB"), []byte{0xa2, 0x41, 0x42}},
+		{"str8", NewStr(strings.Repeat("A", 32)), append([]byte{0xd9, 0x20}, []byte(strings.Repeat("A", 32))...)},
+		{"bin8", NewBin([]byte{0xde, 0xad}), []byte{0xc4, 0x02, 0xde, 0xad}},
+		{"fixarray", NewArray([]*MPObject{NewInt(1), NewNil()}), []byte{0x92, 0x01, 0xc0}},
+		{"fixmap", NewMap([]*MPObject{NewStr("A"), NewInt(1)}), []byte{0x81, 0xa1, 0x41, 0x01}},
+		{"fixext2", NewExt(1, []byte{0x01, 0x02}), []byte{0xd5, 0x01, 0x01, 0x02}},
+		{"ext8", NewExt(1, []byte{0x01, 0x02, 0x03}), []byte{0xc7, 0x03, 0x01, 0x01, 0x02, 0x03}},
+		{"timestamp 32", NewTimestamp(time.Unix(1, 0)), []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{"timestamp 64", NewTimestamp(time.Unix(1, 100)), []byte{0xd7, 0xff, 0x00, 0x00, 0x01, 0x90, 0x00, 0x00, 0x00, 0x01}},
+		{"timestamp 96", NewTimestamp(time.Unix(-1, 0)), []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"event time", NewEventTime(time.Unix(1, 1)), []byte{0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		err := Encode(&buf, v.obj)
+		if err != nil {
+			t.Errorf("%s: Encode error %s", v.casename, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v.expected) {
+			t.Errorf("%s: bytes mismatch. given: %x expect: %x", v.casename, buf.Bytes(), v.expected)
+		}
+
+		ret, err := Decode(bytes.NewBuffer(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		/* event time is not registered */
+		if ret.FormatName != v.obj.FormatName && v.obj.FormatName != "event time" {
+			t.Errorf("%s: FormatName mismatch: %s, expect %s", v.casename, ret.FormatName, v.obj.FormatName)
+		}
+	}
+}
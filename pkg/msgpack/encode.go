@@ -0,0 +1,301 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// Encode writes obj to w as MessagePack.
+// Each object is written using the format of FirstByte and its Value or Child,
+// so the output of Decode is reproduced byte-for-byte unless the tree is edited.
+// It returns an error if an edited value does not fit the format.
+func Encode(w io.Writer, obj *MPObject) error {
+	bw := bufio.NewWriter(w)
+	if err := encode(bw, obj); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func encode(w *bufio.Writer, obj *MPObject) error {
+	if obj == nil {
+		return fmt.Errorf("object is nil")
+	}
+	b := obj.FirstByte
+
+	switch {
+	case isPositiveFixInt(b):
+		v, ok := obj.Int()
+		if !ok || v < 0 || v > 0x7f {
+			return newEncodeError(obj)
+		}
+		return w.WriteByte(byte(v))
+	case isNegativeFixInt(b):
+		v, ok := obj.Int()
+		if !ok || v < -32 || v > -1 {
+			return newEncodeError(obj)
+		}
+		return w.WriteByte(byte(int8(v)))
+	case IsArray(b) || IsMap(b):
+		return encodeCollection(w, obj)
+	case IsString(b):
+		v, ok := obj.Str()
+		if !ok {
+			return newEncodeError(obj)
+		}
+		if err := encodeLength(w, obj, len(v)); err != nil {
+			return err
+		}
+		_, err := w.WriteString(v)
+		return err
+	case IsBin(b):
+		v, ok := obj.Value.([]byte)
+		if !ok {
+			return newEncodeError(obj)
+		}
+		if err := encodeLength(w, obj, len(v)); err != nil {
+			return err
+		}
+		_, err := w.Write(v)
+		return err
+	case IsExt(b):
+		return encodeExt(w, obj)
+	}
+
+	switch b {
+	case NilFormat, NeverUsedFormat:
+		return w.WriteByte(b)
+	case TrueFormat, FalseFormat:
+		v, ok := obj.Bool()
+		if !ok {
+			return newEncodeError(obj)
+		}
+		if v {
+			return w.WriteByte(TrueFormat)
+		}
+		return w.WriteByte(FalseFormat)
+	case Uint8Format, Uint16Format, Uint32Format, Uint64Format:
+		v, ok := obj.Uint()
+		size := 1 << uint(b-Uint8Format)
+		if !ok || (size < 8 && v >= 1<<uint(size*8)) {
+			return newEncodeError(obj)
+		}
+		return encodeNum(w, b, size, v)
+	case Int8Format, Int16Format, Int32Format, Int64Format:
+		v, ok := obj.Int()
+		size := 1 << uint(b-Int8Format)
+		bits := uint(size * 8)
+		if !ok || (size < 8 && (v < -(1<<(bits-1)) || v >= 1<<(bits-1))) {
+			return newEncodeError(obj)
+		}
+		return encodeNum(w, b, size, uint64(v))
+	case Float32Format:
+		if v, ok := obj.Value.(float32); ok {
+			return encodeNum(w, b, 4, uint64(math.Float32bits(v)))
+		}
+		v, ok := obj.Float()
+		if !ok || (float64(float32(v)) != v && !math.IsNaN(v)) {
+			return newEncodeError(obj)
+		}
+		return encodeNum(w, b, 4, uint64(math.Float32bits(float32(v))))
+	case Float64Format:
+		v, ok := obj.Float()
+		if !ok {
+			return newEncodeError(obj)
+		}
+		return encodeNum(w, b, 8, math.Float64bits(v))
+	}
+	return newEncodeError(obj)
+}
+
+func newEncodeError(obj *MPObject) error {
+	return fmt.Errorf("%s(0x%02x) can not encode value %v(%T)", obj.FormatName, obj.FirstByte, obj.Value, obj.Value)
+}
+
+// encodeNum writes b and the lower size bytes of v in big endian.
+func encodeNum(w *bufio.Writer, b byte, size int, v uint64) error {
+	buf := make([]byte, 9)
+	buf[0] = b
+	binary.BigEndian.PutUint64(buf[1:], v)
+	_, err := w.Write(append(buf[:1], buf[9-size:]...))
+	return err
+}
+
+// encodeLength writes the header of obj which has length.
+// For fix family, length is embedded into the first byte.
+func encodeLength(w *bufio.Writer, obj *MPObject, length int) error {
+	b := obj.FirstByte
+	if err := checkLength(obj, length); err != nil {
+		return err
+	}
+
+	switch {
+	case isFixStr(b):
+		return w.WriteByte(0xa0 | byte(length))
+	case isFixArray(b):
+		return w.WriteByte(0x90 | byte(length))
+	case isFixMap(b):
+		return w.WriteByte(0x80 | byte(length))
+	}
+	size := headerSize(b) - 1
+	if isExt(b) {
+		/* ext type is not a part of length field */
+		size--
+	}
+	return encodeNum(w, b, size, uint64(length))
+}
+
+// checkLength reports an error if length exceeds the limit of the format.
+func checkLength(obj *MPObject, length int) error {
+	b := obj.FirstByte
+	var limit int
+	switch {
+	case isFixStr(b):
+		limit = 0x1f
+	case isFixArray(b), isFixMap(b):
+		limit = 0xf
+	case b == Bin8Format, b == Str8Format, b == Ext8Format:
+		limit = math.MaxUint8
+	case b == Bin16Format, b == Str16Format, b == Ext16Format, b == Array16Format, b == Map16Format:
+		limit = math.MaxUint16
+	default:
+		limit = math.MaxUint32
+	}
+	if length > limit {
+		return fmt.Errorf("%s(0x%02x) can not encode length %d", obj.FormatName, b, length)
+	}
+	return nil
+}
+
+// collectionLength returns the length of array or map from the number of children.
+func collectionLength(obj *MPObject) (int, error) {
+	length := len(obj.Child)
+	if IsMap(obj.FirstByte) {
+		if length%2 != 0 {
+			return 0, fmt.Errorf("%s(0x%02x) has odd number of children %d", obj.FormatName, obj.FirstByte, length)
+		}
+		length /= 2
+	}
+	return length, nil
+}
+
+// CheckFormat reports an error if Value or the number of children does not fit the format of FirstByte.
+// Children are not checked recursively.
+func (obj *MPObject) CheckFormat() error {
+	if IsArray(obj.FirstByte) || IsMap(obj.FirstByte) {
+		length, err := collectionLength(obj)
+		if err != nil {
+			return err
+		}
+		return checkLength(obj, length)
+	}
+	return encode(bufio.NewWriter(ioutil.Discard), obj)
+}
+
+func encodeCollection(w *bufio.Writer, obj *MPObject) error {
+	length, err := collectionLength(obj)
+	if err != nil {
+		return err
+	}
+	if err := encodeLength(w, obj, length); err != nil {
+		return err
+	}
+	for _, v := range obj.Child {
+		if err := encode(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeExt(w *bufio.Writer, obj *MPObject) error {
+	data, err := extPayload(obj)
+	if err != nil {
+		return err
+	}
+
+	if isFixExt(obj.FirstByte) {
+		if len(data) != 1<<uint(obj.FirstByte-FixExt1Format) {
+			return fmt.Errorf("%s(0x%02x) can not encode %d bytes", obj.FormatName, obj.FirstByte, len(data))
+		}
+		if _, err := w.Write([]byte{obj.FirstByte, byte(obj.ExtType)}); err != nil {
+			return err
+		}
+	} else {
+		/* ext 8/16/32 has length before type */
+		if err := encodeLength(w, obj, len(data)); err != nil {
+			return err
+		}
+		if err := w.WriteByte(byte(obj.ExtType)); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// extPayload returns the payload of ext family.
+// time.Time is encoded as timestamp or Fluentd event time according to the format.
+// Other values, e.g. the ones returned by ExtFormat.ValueFunc, are encoded as the original payload.
+func extPayload(obj *MPObject) ([]byte, error) {
+	var raw []byte
+	if obj.HeaderSize > 0 && len(obj.Raw) >= obj.HeaderSize {
+		raw = obj.Raw[obj.HeaderSize:]
+	}
+
+	switch v := obj.Value.(type) {
+	case Ext:
+		return v.Data, nil
+	case time.Time:
+		var dec func([]byte) (interface{}, error)
+		var enc func(time.Time) ([]byte, error)
+		switch {
+		case obj.ExtType == -1 && obj.FirstByte == FixExt4Format:
+			dec, enc = decodeTimestamp32, encodeTimestamp32
+		case obj.ExtType == -1 && obj.FirstByte == FixExt8Format:
+			dec, enc = decodeTimestamp64, encodeTimestamp64
+		case obj.ExtType == -1:
+			dec, enc = decodeTimestamp96, encodeTimestamp96
+		case obj.ExtType == 0:
+			dec, enc = decodeEventTimeV1, encodeEventTimeV1
+		case raw != nil:
+			/* time.Time of the ext type registered by the user */
+			return raw, nil
+		default:
+			return nil, newEncodeError(obj)
+		}
+
+		/* keep the original payload if the time is not edited. */
+		if len(raw) > 0 {
+			if t, err := dec(raw); err == nil && t.(time.Time).Equal(v) {
+				return raw, nil
+			}
+		}
+		return enc(v)
+	}
+	if raw != nil {
+		return raw, nil
+	}
+	return nil, newEncodeError(obj)
+}
@@ -0,0 +1,241 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	cases := decodeTestCases()
+
+	cases = append(cases,
+		decodeTestCase{casename: "nested map", bytes: []byte{0x82, 0xa1, 0x30, 0xa1, 0x30, 0xa1, 0x31, 0x83, 0xa1, 0x32, 0xa1, 0x32, 0xa1, 0x33, 0xa1, 0x33, 0xa1, 0x34, 0xa1, 0x34}},
+		decodeTestCase{casename: "non-minimal uint32", bytes: []byte{0xce, 0x00, 0x00, 0x00, 0x05}},
+		decodeTestCase{casename: "non-minimal str8", bytes: []byte{0xd9, 0x01, 0x41}},
+		decodeTestCase{casename: "non-minimal map16", bytes: []byte{0xde, 0x00, 0x01, 0xa1, 0x41, 0x01}},
+		decodeTestCase{casename: "float32 NaN", bytes: []byte{0xca, 0x7f, 0xc0, 0x00, 0x01}},
+		decodeTestCase{casename: "timestamp 32", bytes: []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		decodeTestCase{casename: "timestamp 64", bytes: []byte{0xd7, 0xff, 0x00, 0x00, 0x01, 0x90, 0x00, 0x00, 0x00, 0x01}},
+		decodeTestCase{casename: "timestamp 64 large nsec", bytes: []byte{0xd7, 0xff, 0xff, 0xff, 0xff, 0xfc, 0x00, 0x00, 0x00, 0x01}},
+		decodeTestCase{casename: "timestamp 96", bytes: []byte{0xc7, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+	)
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		ret, err := Decode(bytes.NewBuffer(v.bytes))
+		if ret == nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		err = Encode(&buf, ret)
+		if err != nil {
+			t.Errorf("%s: Encode error %s", v.casename, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v.bytes) {
+			t.Errorf("%s: bytes mismatch.", v.casename)
+			if len(v.bytes) < 64 {
+				t.Errorf(" given : %x", buf.Bytes())
+				t.Errorf(" expect: %x", v.bytes)
+			}
+		}
+	}
+}
+
+func TestEncodeExtValueFunc(t *testing.T) {
+	r := NewExtRegistry()
+	r.Register(&ExtFormat{FirstByte: Ext8Format, ExtType: 1, TypeName: "nested", ValueFunc: func(b []byte) (interface{}, error) {
+		return Decode(bytes.NewBuffer(b))
+	}})
+	r.Register(&ExtFormat{FirstByte: FixExt16Format, ExtType: 2, TypeName: "uuid", ValueFunc: func(b []byte) (interface{}, error) {
+		var v [16]byte
+		copy(v[:], b)
+		return v, nil
+	}})
+	r.Register(&ExtFormat{FirstByte: FixExt4Format, ExtType: 3, TypeName: "string", ValueFunc: func(b []byte) (interface{}, error) {
+		return string(b), nil
+	}})
+	r.Register(&ExtFormat{FirstByte: FixExt4Format, ExtType: 4, TypeName: "unix time", ValueFunc: func(b []byte) (interface{}, error) {
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+	}})
+	r.Register(&ExtFormat{FirstByte: FixExt4Format, ExtType: 5, TypeName: "xor", ValueFunc: func(b []byte) (interface{}, error) {
+		ret := make([]byte, len(b))
+		for i := range b {
+			ret[i] = ^b[i]
+		}
+		return ret, nil
+	}})
+
+	type testcase struct {
+		casename string
+		bytes    []byte
+	}
+
+	cases := []testcase{
+		{"nested", []byte{0xc7, 0x02, 0x01, 0x91, 0x01}},
+		{"uuid", append([]byte{0xd8, 0x02}, bytes.Repeat([]byte{0xab}, 16)...)},
+		{"string", []byte{0xd6, 0x03, 0x41, 0x42, 0x43, 0x44}},
+		{"unix time", []byte{0xd6, 0x04, 0x00, 0x00, 0x00, 0x01}},
+		{"bytes", []byte{0xd6, 0x05, 0x00, 0x01, 0x02, 0x03}},
+		{"in array", []byte{0x92, 0xd6, 0x03, 0x41, 0x42, 0x43, 0x44, 0xc7, 0x02, 0x01, 0x91, 0x01}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		obj, err := NewDecoder(bytes.NewReader(v.bytes), WithExtRegistry(r)).Decode()
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		if err := Encode(&buf, obj); err != nil {
+			t.Errorf("%s: Encode error %s", v.casename, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v.bytes) {
+			t.Errorf("%s: bytes mismatch. given: %x expect: %x", v.casename, buf.Bytes(), v.bytes)
+		}
+	}
+
+	/* Value is the decoded bytes, not the payload */
+	obj, err := NewDecoder(bytes.NewReader([]byte{0xd6, 0x05, 0x00, 0x01, 0x02, 0x03}), WithExtRegistry(r)).Decode()
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	if v, ok := obj.Value.([]byte); !ok || !bytes.Equal(v, []byte{0xff, 0xfe, 0xfd, 0xfc}) || obj.DataStr != "0xfffefdfc" {
+		t.Errorf("value mismatch. given: %v %s", obj.Value, obj.DataStr)
+	}
+}
+
+func TestEncodeEdited(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		edit     func(obj *MPObject)
+		expected []byte
+	}
+
+	cases := []testcase{
+		{"uint16", []byte{0xcd, 0x00, 0x01}, func(obj *MPObject) { obj.SetValue(uint64(0x1234)) }, []byte{0xcd, 0x12, 0x34}},
+		{"fixstr", []byte{0xa1, 0x41}, func(obj *MPObject) { obj.SetValue("ABC") }, []byte{0xa3, 0x41, 0x42, 0x43}},
+		{"str8", []byte{0xd9, 0x01, 0x41}, func(obj *MPObject) { obj.SetValue("AB") }, []byte{0xd9, 0x02, 0x41, 0x42}},
+		{"fixarray append", []byte{0x91, 0x01}, func(obj *MPObject) {
+			obj.Child = append(obj.Child, NewInteger(2))
+		}, []byte{0x92, 0x01, 0x02}},
+		{"true to false", []byte{0xc3}, func(obj *MPObject) { obj.SetValue(false) }, []byte{0xc2}},
+		{"timestamp 32", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, func(obj *MPObject) { obj.SetValue(time.Unix(2, 0)) }, []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x02}},
+		{"ext8", []byte{0xc7, 0x01, 0x01, 0x00}, func(obj *MPObject) { obj.SetValue(Ext{Type: 1, Data: []byte{0x01, 0x02}}) }, []byte{0xc7, 0x02, 0x01, 0x01, 0x02}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		ret, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		v.edit(ret)
+		err = Encode(&buf, ret)
+		if err != nil {
+			t.Errorf("%s: Encode error %s", v.casename, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), v.expected) {
+			t.Errorf("%s: bytes mismatch. given: %x expect: %x", v.casename, buf.Bytes(), v.expected)
+		}
+
+		/* JSON of the edited obj is the same as the one of the encoded bytes */
+		dec, err := Decode(bytes.NewBuffer(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		edited, _ := json.Marshal(ret)
+		encoded, _ := json.Marshal(dec)
+		if !bytes.Equal(edited, encoded) {
+			t.Errorf("%s: JSON mismatch. given: %s expect: %s", v.casename, edited, encoded)
+		}
+	}
+}
+
+func TestEncodeOverflow(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *MPObject
+	}
+
+	cases := []testcase{
+		{"positive fixint", &MPObject{FirstByte: 0x01, Value: int64(0x80)}},
+		{"negative fixint", &MPObject{FirstByte: 0xff, Value: int64(-33)}},
+		{"uint8", &MPObject{FirstByte: Uint8Format, Value: uint64(0x100)}},
+		{"int16", &MPObject{FirstByte: Int16Format, Value: int64(-0x8001)}},
+		{"fixstr", &MPObject{FirstByte: 0xa0, Value: string(make([]byte, 32))}},
+		{"float32", &MPObject{FirstByte: Float32Format, Value: float64(0.1)}},
+		{"fixext1", &MPObject{FirstByte: FixExt1Format, Value: Ext{Data: []byte{0x01, 0x02}}}},
+		{"fixmap odd", &MPObject{FirstByte: 0x81, Child: []*MPObject{{FirstByte: 0x01, Value: int64(1)}}}},
+		{"type mismatch", &MPObject{FirstByte: Str8Format, Value: int64(1)}},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		if Encode(&buf, v.obj) == nil {
+			t.Errorf("%s: No error is detected", v.casename)
+		}
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *MPObject
+		ok       bool
+	}
+
+	nils := make([]*MPObject, 16)
+	for i := range nils {
+		nils[i] = NewNil()
+	}
+
+	cases := []testcase{
+		{"uint8", &MPObject{FirstByte: Uint8Format, Value: uint64(0xff)}, true},
+		{"uint8 overflow", &MPObject{FirstByte: Uint8Format, Value: uint64(0x100)}, false},
+		{"fixstr", &MPObject{FirstByte: 0xa0, Value: "abc"}, true},
+		{"fixstr overflow", &MPObject{FirstByte: 0xa0, Value: string(make([]byte, 32))}, false},
+		{"fixarray", &MPObject{FirstByte: 0x90, Child: nils[:15]}, true},
+		{"fixarray overflow", &MPObject{FirstByte: 0x90, Child: nils}, false},
+		{"array16", &MPObject{FirstByte: Array16Format, Child: nils}, true},
+		{"fixmap odd", &MPObject{FirstByte: 0x80, Child: nils[:3]}, false},
+		/* children are not checked */
+		{"fixarray broken child", &MPObject{FirstByte: 0x91, Child: []*MPObject{{FirstByte: Uint8Format, Value: uint64(0x100)}}}, true},
+	}
+
+	for _, v := range cases {
+		err := v.obj.CheckFormat()
+		if v.ok && err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+		} else if !v.ok && err == nil {
+			t.Errorf("%s: No error is detected", v.casename)
+		}
+	}
+}
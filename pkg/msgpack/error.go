@@ -0,0 +1,131 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Categories of DecodeError. Use errors.Is to check the category.
+var (
+	// ErrTruncated means the input ends before the object is complete.
+	ErrTruncated = errors.New("truncated data")
+	// ErrNeverUsed means the input contains 0xc1 which is never used by the spec.
+	// It is reported in strict mode. Otherwise it is added to MPObject.Diagnostics.
+	ErrNeverUsed = errors.New("never used byte")
+	// ErrLimitExceeded means the object exceeds a limit of the decoder.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrDepthLimit means the nesting depth exceeds Limits.MaxDepth.
+	ErrDepthLimit = fmt.Errorf("depth %w", ErrLimitExceeded)
+	// ErrLengthLimit means the length of array or map exceeds Limits.MaxLength.
+	ErrLengthLimit = fmt.Errorf("length %w", ErrLimitExceeded)
+	// ErrSizeLimit means the payload of str, bin or ext exceeds Limits.MaxSize.
+	ErrSizeLimit = fmt.Errorf("size %w", ErrLimitExceeded)
+	// ErrBytesLimit means the input exceeds Limits.MaxBytes.
+	ErrBytesLimit = fmt.Errorf("bytes %w", ErrLimitExceeded)
+	// ErrInvalidUTF8 means str object is not valid UTF-8. It is reported in strict mode.
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+)
+
+// DecodeError describes where and why decoding failed.
+type DecodeError struct {
+	Kind   error  /* ErrTruncated, ErrNeverUsed, one of limit errors or ErrInvalidUTF8 */
+	Offset int64  /* position in the input where the error is detected */
+	Path   string /* container path of the broken object. e.g. $[3].tags["host"] */
+	Format string /* format name being read */
+	Need   int    /* number of bytes needed */
+	Have   int    /* number of bytes available */
+	Err    error  /* underlying error. e.g. io.ErrUnexpectedEOF */
+}
+
+// Error implements error interface.
+func (e *DecodeError) Error() string {
+	kind := "decode error"
+	if e.Kind != nil {
+		kind = e.Kind.Error()
+	}
+	msg := fmt.Sprintf("msgpack: %s at offset %d, path %s, format %s", kind, e.Offset, e.Path, e.Format)
+	if e.Need > 0 {
+		msg += fmt.Sprintf(": needs %d bytes, %d bytes available", e.Need, e.Have)
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %s", e.Err)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the category of e is target.
+// Limit errors such as ErrDepthLimit are also ErrLimitExceeded.
+func (e *DecodeError) Is(target error) bool {
+	return e.Kind != nil && errors.Is(e.Kind, target)
+}
+
+// pathElem represents an element of the containers being decoded.
+type pathElem struct {
+	obj   *MPObject
+	index int       /* index of the child being read */
+	key   *MPObject /* the last key read if obj is map */
+}
+
+// formatPath converts the containers being decoded into a string like $[3].tags["host"].
+func formatPath(elems []pathElem) string {
+	path := make(Path, 0, len(elems))
+	for _, v := range elems {
+		switch {
+		case IsArray(v.obj.FirstByte):
+			path = append(path, PathElem{Index: v.index})
+		case IsMap(v.obj.FirstByte) && v.index%2 == 1:
+			path = append(path, PathElem{Index: v.index / 2, Key: v.key})
+		}
+	}
+	return path.String()
+}
+
+// formatKey converts a map key into a path element.
+func formatKey(key *MPObject) string {
+	switch {
+	case key == nil:
+		return "[?]"
+	case IsString(key.FirstByte) && isIdentifier(key.DataStr):
+		return "." + key.DataStr
+	case IsString(key.FirstByte):
+		return "[" + strconv.Quote(key.DataStr) + "]"
+	}
+	return "[" + key.DataStr + "]"
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
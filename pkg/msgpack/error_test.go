@@ -0,0 +1,128 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		kind     error
+		offset   int64
+		path     string
+		format   string
+		need     int
+		have     int
+	}
+
+	cases := []testcase{
+		{"shorten str8", []byte{0xd9, 0x05, 0x41, 0x42}, ErrTruncated, 4, "$", "str 8", 5, 2},
+		{"shorten uint16", []byte{0xcd, 0x01}, ErrTruncated, 2, "$", "uint 16", 2, 1},
+		{"shorten uint32", []byte{0xce, 0x01}, ErrTruncated, 2, "$", "uint 32", 4, 1},
+		{"shorten uint64", []byte{0xcf}, ErrTruncated, 1, "$", "uint 64", 8, 0},
+		{"shorten int32", []byte{0xd2, 0x01, 0x02}, ErrTruncated, 3, "$", "int 32", 4, 2},
+		{"shorten float64", []byte{0xcb, 0x01}, ErrTruncated, 2, "$", "float 64", 8, 1},
+		{"array element", []byte{0x93, 0x01, 0x02}, ErrTruncated, 3, "$[2]", "fixarray", 1, 0},
+		{"nested", []byte{0x92, 0x01, 0x81, 0xa4, 0x74, 0x61, 0x67, 0x73, 0x81, 0xa4, 0x68, 0x6f, 0x73, 0x74, 0xd9, 0x03},
+			ErrTruncated, 16, "$[1].tags.host", "str 8", 3, 0},
+		{"not identifier key", []byte{0x81, 0xa3, 0x61, 0x2d, 0x62, 0xcd}, ErrTruncated, 6, `$["a-b"]`, "uint 16", 2, 0},
+		{"int key", []byte{0x81, 0x01, 0x92, 0xc0}, ErrTruncated, 4, "$[1][1]", "fixarray", 1, 0},
+		{"never used", []byte{0x92, 0x01, 0xc1}, ErrNeverUsed, 2, "$[1]", "(never used)", 0, 0},
+	}
+
+	for _, v := range cases {
+		/* 0xc1 is an error only in strict mode */
+		_, err := NewDecoder(bytes.NewReader(v.bytes), Strict()).Decode()
+		if !errors.Is(err, v.kind) {
+			t.Errorf("%s: kind mismatch. err=%v", v.casename, err)
+		}
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: not DecodeError. err=%v", v.casename, err)
+			continue
+		}
+		if e.Offset != v.offset {
+			t.Errorf("%s: Offset mismatch: %d, expect %d", v.casename, e.Offset, v.offset)
+		}
+		if e.Path != v.path {
+			t.Errorf("%s: Path mismatch: %s, expect %s", v.casename, e.Path, v.path)
+		}
+		if e.Format != v.format {
+			t.Errorf("%s: Format mismatch: %s, expect %s", v.casename, e.Format, v.format)
+		}
+		if e.Need != v.need || e.Have != v.have {
+			t.Errorf("%s: Need/Have mismatch: %d/%d, expect %d/%d", v.casename, e.Need, e.Have, v.need, v.have)
+		}
+		if v.kind == ErrTruncated && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: io.ErrUnexpectedEOF is not wrapped", v.casename)
+		}
+	}
+}
+
+func TestNeverUsed(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		children int
+		offsets  []int64
+		paths    []string
+	}
+
+	cases := []testcase{
+		{"top level", []byte{0xc1}, 0, []int64{0}, []string{"$"}},
+		{"in array", []byte{0x93, 0x01, 0xc1, 0x02}, 3, []int64{2}, []string{"$[1]"}},
+		{"twice", []byte{0x93, 0xc1, 0xc1, 0xcd, 0x00, 0x01}, 3, []int64{1, 2}, []string{"$[0]", "$[1]"}},
+		{"map value", []byte{0x82, 0xa1, 0x41, 0xc1, 0xa1, 0x42, 0x01}, 4, []int64{3}, []string{"$.A"}},
+	}
+
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+			continue
+		}
+		if len(obj.Child) != v.children {
+			t.Errorf("%s: children mismatch. given=%d expected=%d", v.casename, len(obj.Child), v.children)
+			continue
+		}
+		var found []*DecodeError
+		Walk(obj, func(path Path, o *MPObject) error {
+			for _, d := range o.Diagnostics {
+				var e *DecodeError
+				if errors.As(d, &e) && errors.Is(e, ErrNeverUsed) {
+					found = append(found, e)
+				}
+			}
+			return nil
+		})
+		if len(found) != len(v.offsets) {
+			t.Errorf("%s: diagnostics mismatch. given=%v", v.casename, found)
+			continue
+		}
+		for i, e := range found {
+			if e.Offset != v.offsets[i] || e.Path != v.paths[i] {
+				t.Errorf("%s: %d: given offset=%d path=%s expected offset=%d path=%s", v.casename, i, e.Offset, e.Path, v.offsets[i], v.paths[i])
+			}
+		}
+	}
+}
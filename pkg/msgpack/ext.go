@@ -17,9 +17,10 @@
 package msgpack
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 )
 
@@ -28,7 +29,11 @@ type ExtFormat struct {
 	FirstByte  byte
 	ExtType    int8
 	TypeName   string
-	DecodeFunc func([]byte) string
+	DecodeFunc func([]byte) string /* converts payload into string. it is used if ValueFunc is nil */
+
+	/* ValueFunc converts payload into typed value. e.g. time.Time, UUID or *MPObject of nested MessagePack.
+	   If it returns an error, the payload is kept as Ext and the error is added to MPObject.Diagnostics. */
+	ValueFunc func([]byte) (interface{}, error)
 }
 
 // String implements Stringer interface.
@@ -36,102 +41,246 @@ func (obj *ExtFormat) String() string {
 	return fmt.Sprintf(`%s(0x%02x): type=%d`, obj.TypeName, obj.FirstByte, obj.ExtType)
 }
 
+// ExtError describes the failure of ExtFormat.ValueFunc.
+type ExtError struct {
+	Offset   int64  /* position of the ext object in the input */
+	Type     int8   /* ext type */
+	TypeName string /* ExtFormat.TypeName */
+	Err      error  /* error returned by ValueFunc */
+}
+
+// Error implements error interface.
+func (e *ExtError) Error() string {
+	return fmt.Sprintf("%s (type %d) at offset %d: %s", e.TypeName, e.Type, e.Offset, e.Err)
+}
+
+// Unwrap returns the error returned by ValueFunc.
+func (e *ExtError) Unwrap() error {
+	return e.Err
+}
+
 /* Decode functions for Timestamp extension type. */
 /* https://github.com/msgpack/msgpack/blob/master/spec.md#extension-types */
-func timestamp32(b []byte) string {
+func decodeTimestamp32(b []byte) (interface{}, error) {
 	if len(b) != 4 {
-		return ""
+		return nil, fmt.Errorf("timestamp 32 must be 4 bytes, but %d bytes", len(b))
 	}
-	return fmt.Sprintf("%v", time.Unix(int64(binary.BigEndian.Uint32(b)), 0))
+	return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
 }
-func timestamp64(b []byte) string {
+func decodeTimestamp64(b []byte) (interface{}, error) {
 	if len(b) != 8 {
-		return ""
+		return nil, fmt.Errorf("timestamp 64 must be 8 bytes, but %d bytes", len(b))
 	}
 	raw := binary.BigEndian.Uint64(b)
 	sec := int64(raw & 0x3FFFFFFFF)
 	nsec := int64((raw & 0xFFFFFFFC00000000) >> 34)
-	return fmt.Sprintf("%v", time.Unix(sec, nsec))
+	if err := checkNanoseconds(nsec); err != nil {
+		return nil, err
+	}
+	return time.Unix(sec, nsec), nil
 }
-func timestamp96(b []byte) string {
+func decodeTimestamp96(b []byte) (interface{}, error) {
 	if len(b) != 12 {
-		return ""
+		return nil, fmt.Errorf("timestamp 96 must be 12 bytes, but %d bytes", len(b))
 	}
 	nsec := int64(binary.BigEndian.Uint32(b[0:4]))
-	var sec int64
-	if binary.Read(bytes.NewReader(b[4:]), binary.BigEndian, &sec) != nil {
-		return ""
+	sec := int64(binary.BigEndian.Uint64(b[4:]))
+	if err := checkNanoseconds(nsec); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("%v", time.Unix(sec, nsec))
+	return time.Unix(sec, nsec), nil
+}
+
+func checkNanoseconds(nsec int64) error {
+	if nsec < 0 || nsec > 999999999 {
+		return fmt.Errorf("nanoseconds %d is out of range 0 to 999999999", nsec)
+	}
+	return nil
+}
+
+/* Encode functions for Timestamp extension type. */
+func encodeTimestamp32(t time.Time) ([]byte, error) {
+	if t.Nanosecond() != 0 || t.Unix() < 0 || t.Unix() > math.MaxUint32 {
+		return nil, fmt.Errorf("timestamp 32 can not encode %v", t)
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	return b, nil
+}
+func encodeTimestamp64(t time.Time) ([]byte, error) {
+	if t.Unix() < 0 || t.Unix() >= 1<<34 {
+		return nil, fmt.Errorf("timestamp 64 can not encode %v", t)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.Nanosecond())<<34|uint64(t.Unix()))
+	return b, nil
+}
+func encodeTimestamp96(t time.Time) ([]byte, error) {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Nanosecond()))
+	binary.BigEndian.PutUint64(b[4:], uint64(t.Unix()))
+	return b, nil
 }
 
 /* Fluentd EventTime Ext Format */
 /* https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1 */
-func extEventTimeV1(b []byte) string {
+func decodeEventTimeV1(b []byte) (interface{}, error) {
 	if len(b) != 8 {
-		return ""
+		return nil, fmt.Errorf("event time must be 8 bytes, but %d bytes", len(b))
 	}
-	var sec int32
-	if binary.Read(bytes.NewReader(b[:4]), binary.BigEndian, &sec) != nil {
-		return ""
+	sec := int32(binary.BigEndian.Uint32(b[:4]))
+	nsec := int32(binary.BigEndian.Uint32(b[4:]))
+	if err := checkNanoseconds(int64(nsec)); err != nil {
+		return nil, err
 	}
-	var nsec int32
-	if binary.Read(bytes.NewReader(b[4:]), binary.BigEndian, &nsec) != nil {
-		return ""
+	return time.Unix(int64(sec), int64(nsec)), nil
+}
+func encodeEventTimeV1(t time.Time) ([]byte, error) {
+	if t.Unix() < math.MinInt32 || t.Unix() > math.MaxInt32 {
+		return nil, fmt.Errorf("event time can not encode %v", t)
 	}
-	return fmt.Sprintf("%v", time.Unix(int64(sec), int64(nsec)))
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b, nil
 }
 
-var extFormats map[byte]([]*ExtFormat) = map[byte]([]*ExtFormat){
-	FixExt4Format: []*ExtFormat{&ExtFormat{FirstByte: FixExt4Format, ExtType: -1, TypeName: "timestamp 32", DecodeFunc: timestamp32}},
-	FixExt8Format: []*ExtFormat{&ExtFormat{FirstByte: FixExt8Format, ExtType: -1, TypeName: "timestamp 64", DecodeFunc: timestamp64}},
-	Ext8Format:    []*ExtFormat{&ExtFormat{FirstByte: Ext8Format, ExtType: -1, TypeName: "timestamp 96", DecodeFunc: timestamp96}},
+// extKey identifies ext format in ExtRegistry.
+type extKey struct {
+	firstByte byte
+	extType   int8
 }
 
-// RegisterFluentdEventTime registers Fluentd ext timestamp format.
-// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1 */
-func RegisterFluentdEventTime() {
-	RegisterExt(&ExtFormat{FirstByte: FixExt8Format, ExtType: 0, TypeName: "event time", DecodeFunc: extEventTimeV1})
-	RegisterExt(&ExtFormat{FirstByte: Ext8Format, ExtType: 0, TypeName: "event time", DecodeFunc: extEventTimeV1})
+// ExtRegistry is a set of ext formats which Decoder decodes into values.
+// It is safe for concurrent use by multiple goroutines.
+type ExtRegistry struct {
+	mu      sync.RWMutex
+	formats map[extKey]*ExtFormat
 }
 
-// RegisterExt register user defined ext format.
-func RegisterExt(ext *ExtFormat) error {
+// NewExtRegistry returns a registry which has the timestamp ext formats defined by the spec.
+func NewExtRegistry() *ExtRegistry {
+	r := &ExtRegistry{formats: map[extKey]*ExtFormat{}}
+	r.Register(&ExtFormat{FirstByte: FixExt4Format, ExtType: -1, TypeName: "timestamp 32", ValueFunc: decodeTimestamp32})
+	r.Register(&ExtFormat{FirstByte: FixExt8Format, ExtType: -1, TypeName: "timestamp 64", ValueFunc: decodeTimestamp64})
+	r.Register(&ExtFormat{FirstByte: Ext8Format, ExtType: -1, TypeName: "timestamp 96", ValueFunc: decodeTimestamp96})
+	return r
+}
+
+// Clone returns a copy of r. Registering to the copy does not affect r.
+func (r *ExtRegistry) Clone() *ExtRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c := &ExtRegistry{formats: make(map[extKey]*ExtFormat, len(r.formats))}
+	for k, v := range r.formats {
+		c.formats[k] = v
+	}
+	return c
+}
+
+// Register registers ext format.
+// It replaces the format which has the same FirstByte and ExtType.
+func (r *ExtRegistry) Register(ext *ExtFormat) error {
 	if ext == nil {
 		return fmt.Errorf("ext pointer is nil")
-	} else if ext.DecodeFunc == nil {
-		return fmt.Errorf("DecodeFunc is nil")
+	} else if ext.DecodeFunc == nil && ext.ValueFunc == nil {
+		return fmt.Errorf("DecodeFunc and ValueFunc are nil")
 	} else if !IsExt(ext.FirstByte) {
 		return fmt.Errorf("0x%02x is not ext type", ext.FirstByte)
 	}
 
-	extFormats[ext.FirstByte] = append(extFormats[ext.FirstByte], ext)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formats[extKey{ext.FirstByte, ext.ExtType}] = ext
 	return nil
 }
 
-func (obj *MPObject) setExtType(buf *bytes.Buffer) error {
-	types := buf.Next(1)
-	obj.Raw = append(obj.Raw, types...)
+// Unregister removes the format of firstByte and extType.
+// It reports whether the format was registered.
+func (r *ExtRegistry) Unregister(firstByte byte, extType int8) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := extKey{firstByte, extType}
+	_, ok := r.formats[key]
+	delete(r.formats, key)
+	return ok
+}
+
+// Lookup returns the format of firstByte and extType.
+func (r *ExtRegistry) Lookup(firstByte byte, extType int8) (*ExtFormat, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ext, ok := r.formats[extKey{firstByte, extType}]
+	return ext, ok
+}
+
+// RegisterFluentdEventTime registers Fluentd ext timestamp format.
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+func (r *ExtRegistry) RegisterFluentdEventTime() {
+	r.Register(&ExtFormat{FirstByte: FixExt8Format, ExtType: 0, TypeName: "event time", ValueFunc: decodeEventTimeV1})
+	r.Register(&ExtFormat{FirstByte: Ext8Format, ExtType: 0, TypeName: "event time", ValueFunc: decodeEventTimeV1})
+}
+
+// defaultExtRegistry is used by Decoder unless WithExtRegistry is specified.
+var defaultExtRegistry = NewExtRegistry()
+
+// DefaultExtRegistry returns the registry which RegisterExt and RegisterFluentdEventTime modify.
+// Decoder uses it unless WithExtRegistry is specified.
+func DefaultExtRegistry() *ExtRegistry {
+	return defaultExtRegistry
+}
+
+// WithExtRegistry makes the decoder use r instead of the default registry.
+// The decoder does not modify r.
+func WithExtRegistry(r *ExtRegistry) DecoderOption {
+	return func(d *Decoder) {
+		if r != nil {
+			d.exts = r
+		}
+	}
+}
+
+// RegisterFluentdEventTime registers Fluentd ext timestamp format to the default registry.
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1 */
+func RegisterFluentdEventTime() {
+	defaultExtRegistry.RegisterFluentdEventTime()
+}
 
-	var v int8
-	err := binary.Read(bytes.NewReader(types), binary.BigEndian, &v)
+// RegisterExt register user defined ext format to the default registry.
+func RegisterExt(ext *ExtFormat) error {
+	return defaultExtRegistry.Register(ext)
+}
+
+func (obj *MPObject) setExtType(d *Decoder) error {
+	types, err := d.next(obj, 1)
 	if err != nil {
 		return err
 	}
-	obj.ExtType = v
+	obj.ExtType = int8(types[0])
 	return nil
 }
 
-func (obj *MPObject) setRegisteredExt(extData []byte) bool {
-	list, ok := extFormats[obj.FirstByte]
-	if ok && len(list) > 0 {
-		for _, v := range list {
-			if v.ExtType == obj.ExtType {
-				obj.FormatName = v.TypeName
-				obj.DataStr = v.DecodeFunc(extData)
-				return true
-			}
-		}
+// setRegisteredExt decodes the payload by the format registered in r.
+// It returns false if the format is not registered.
+func (obj *MPObject) setRegisteredExt(r *ExtRegistry, extData []byte) bool {
+	v, ok := r.Lookup(obj.FirstByte, obj.ExtType)
+	if !ok {
+		return false
+	}
+	obj.FormatName = v.TypeName
+	if v.ValueFunc == nil {
+		/* only string representation is available */
+		obj.Value = Ext{Type: obj.ExtType, Data: extData}
+		obj.DataStr = v.DecodeFunc(extData)
+		return true
+	}
+	val, err := v.ValueFunc(extData)
+	if err != nil {
+		obj.SetValue(Ext{Type: obj.ExtType, Data: extData})
+		obj.Diagnostics = append(obj.Diagnostics, &ExtError{Offset: obj.Offset, Type: obj.ExtType, TypeName: v.TypeName, Err: err})
+		return true
 	}
-	return false
+	obj.SetValue(val)
+	return true
 }
@@ -17,14 +17,20 @@
 package msgpack
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestTimestampExt(t *testing.T) {
+	r := NewExtRegistry()
+
 	tm32 := &MPObject{FirstByte: 0xd6, ExtType: -1}
-	if !tm32.setRegisteredExt([]byte{0x00, 0x00, 0x00, 0x01}) {
+	if !tm32.setRegisteredExt(r, []byte{0x00, 0x00, 0x00, 0x01}) {
 		t.Error("tm32.setRegisteredExt Failed")
 	}
 	timestr := fmt.Sprintf("%v", time.Unix(1, 0))
@@ -33,7 +39,7 @@ func TestTimestampExt(t *testing.T) {
 	}
 
 	tm64 := &MPObject{FirstByte: 0xd7, ExtType: -1}
-	if !tm64.setRegisteredExt([]byte{0x00, 0x00, 0x01, 0x90, 0x00, 0x00, 0x00, 0x01}) {
+	if !tm64.setRegisteredExt(r, []byte{0x00, 0x00, 0x01, 0x90, 0x00, 0x00, 0x00, 0x01}) {
 		t.Error("tm64.setRegisteredExt Failed")
 	}
 	timestr = fmt.Sprintf("%v", time.Unix(1, 100))
@@ -42,7 +48,7 @@ func TestTimestampExt(t *testing.T) {
 	}
 
 	tm96 := &MPObject{FirstByte: 0xc7, ExtType: -1}
-	if !tm96.setRegisteredExt([]byte{0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}) {
+	if !tm96.setRegisteredExt(r, []byte{0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}) {
 		t.Error("tm96.setRegisteredExt Failed")
 	}
 	timestr = fmt.Sprintf("%v", time.Unix(1, 100))
@@ -52,10 +58,11 @@ func TestTimestampExt(t *testing.T) {
 }
 
 func TestFluentdEventTime(t *testing.T) {
-	RegisterFluentdEventTime()
+	r := NewExtRegistry()
+	r.RegisterFluentdEventTime()
 
 	fixext8 := &MPObject{FirstByte: 0xd7, ExtType: 0}
-	if !fixext8.setRegisteredExt([]byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}) {
+	if !fixext8.setRegisteredExt(r, []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}) {
 		t.Errorf("fixext8.setRegisteredExt Failed")
 	}
 	timestr := fmt.Sprintf("%v", time.Unix(1, 1))
@@ -64,7 +71,7 @@ func TestFluentdEventTime(t *testing.T) {
 	}
 
 	ext8 := &MPObject{FirstByte: 0xc7, ExtType: 0}
-	if !ext8.setRegisteredExt([]byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}) {
+	if !ext8.setRegisteredExt(r, []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}) {
 		t.Errorf("ext8.setRegisteredExt Failed")
 	}
 	timestr = fmt.Sprintf("%v", time.Unix(1, 1))
@@ -96,3 +103,170 @@ func TestBlockRegisterIllegalExt(t *testing.T) {
 		}
 	}
 }
+
+func TestExtRegistry(t *testing.T) {
+	r := NewExtRegistry()
+	if _, ok := r.Lookup(FixExt8Format, 0); ok {
+		t.Errorf("event time is registered by default")
+	}
+	c := r.Clone()
+	c.RegisterFluentdEventTime()
+	if _, ok := c.Lookup(FixExt8Format, 0); !ok {
+		t.Errorf("event time is not registered")
+	}
+	if _, ok := r.Lookup(FixExt8Format, 0); ok {
+		t.Errorf("registration to the clone affects the original")
+	}
+
+	/* replace */
+	ext := &ExtFormat{FirstByte: FixExt8Format, ExtType: 0, TypeName: "custom", DecodeFunc: dummyDecodeFunc}
+	if err := c.Register(ext); err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if v, ok := c.Lookup(FixExt8Format, 0); !ok || v != ext {
+		t.Errorf("format is not replaced. given=%v", v)
+	}
+
+	if !c.Unregister(FixExt8Format, 0) {
+		t.Errorf("Unregister returns false")
+	}
+	if c.Unregister(FixExt8Format, 0) {
+		t.Errorf("Unregister returns true for unregistered format")
+	}
+	if _, ok := c.Lookup(FixExt8Format, 0); ok {
+		t.Errorf("format is not unregistered")
+	}
+	if _, ok := c.Lookup(Ext8Format, 0); !ok {
+		t.Errorf("other format is unregistered")
+	}
+}
+
+func TestDecodeWithExtRegistry(t *testing.T) {
+	/* fixext 8, type 0 */
+	b := []byte{0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}
+
+	r := NewExtRegistry()
+	r.RegisterFluentdEventTime()
+	obj, err := NewDecoder(bytes.NewReader(b), WithExtRegistry(r)).Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if v, ok := obj.Time(); !ok || !v.Equal(time.Unix(1, 1)) || obj.FormatName != "event time" {
+		t.Errorf("event time is not decoded. given=%s", obj)
+	}
+
+	obj, err = NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if obj.FormatName != "fixext 8" {
+		t.Errorf("default registry has event time. given=%s", obj)
+	}
+
+	/* decoders with different registries run concurrently */
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reg := NewExtRegistry()
+			expected := "fixext 8"
+			if i%2 == 0 {
+				reg.RegisterFluentdEventTime()
+				expected = "event time"
+			}
+			for j := 0; j < 100; j++ {
+				obj, err := NewDecoder(bytes.NewReader(b), WithExtRegistry(reg)).Decode()
+				if err != nil || obj.FormatName != expected {
+					t.Errorf("%d: mismatch. given=%s err=%v", i, obj, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRegisterFluentdEventTime(t *testing.T) {
+	defer func() {
+		/* do not leak the registration to other tests */
+		DefaultExtRegistry().Unregister(FixExt8Format, 0)
+		DefaultExtRegistry().Unregister(Ext8Format, 0)
+	}()
+
+	RegisterFluentdEventTime()
+	/* ext 8, length 8, type 0 */
+	b := []byte{0xc7, 0x08, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}
+	obj, err := Decode(bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if obj.FormatName != "event time" {
+		t.Errorf("event time is not decoded. given=%s", obj)
+	}
+}
+
+func TestExtValueFunc(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		value    interface{}
+		err      string /* ExtError.Error() */
+	}
+
+	/* ValueFunc decodes nested MessagePack */
+	nested := &ExtFormat{FirstByte: Ext8Format, ExtType: 1, TypeName: "nested", ValueFunc: func(b []byte) (interface{}, error) {
+		return Decode(bytes.NewBuffer(b))
+	}}
+	uuid := &ExtFormat{FirstByte: FixExt16Format, ExtType: 2, TypeName: "uuid", ValueFunc: func(b []byte) (interface{}, error) {
+		var v [16]byte
+		copy(v[:], b)
+		return v, nil
+	}}
+	r := NewExtRegistry()
+	r.Register(nested)
+	r.Register(uuid)
+
+	cases := []testcase{
+		{"timestamp 32", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, time.Unix(1, 0), ""},
+		{"timestamp 64 nsec", []byte{0xd7, 0xff, 0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x01}, Ext{Type: -1, Data: []byte{0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x01}},
+			"timestamp 64 (type -1) at offset 0: nanoseconds 1000000000 is out of range 0 to 999999999"},
+		{"timestamp 96 size", []byte{0xc7, 0x01, 0xff, 0x00}, Ext{Type: -1, Data: []byte{0x00}}, "timestamp 96 (type -1) at offset 0: timestamp 96 must be 12 bytes, but 1 bytes"},
+		{"uuid", append([]byte{0xd8, 0x02}, make([]byte, 16)...), [16]byte{}, ""},
+		{"nested error", []byte{0xc7, 0x01, 0x01, 0x92}, Ext{Type: 1, Data: []byte{0x92}}, ""},
+	}
+
+	for _, v := range cases {
+		obj, err := NewDecoder(bytes.NewReader(v.bytes), WithExtRegistry(r)).Decode()
+		if err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj.Value, v.value) {
+			t.Errorf("%s: value mismatch. given=%#v", v.casename, obj.Value)
+		}
+		if v.value == nil || reflect.TypeOf(v.value) != reflect.TypeOf(Ext{}) {
+			if len(obj.Diagnostics) != 0 {
+				t.Errorf("%s: unexpected diagnostics %v", v.casename, obj.Diagnostics)
+			}
+			continue
+		}
+		var e *ExtError
+		if len(obj.Diagnostics) != 1 || !errors.As(obj.Diagnostics[0], &e) {
+			t.Errorf("%s: diagnostics mismatch. given=%v", v.casename, obj.Diagnostics)
+			continue
+		}
+		if v.err != "" && e.Error() != v.err {
+			t.Errorf("%s: error mismatch. given=%s", v.casename, e)
+		}
+	}
+
+	/* nested MessagePack */
+	obj, err := NewDecoder(bytes.NewReader([]byte{0xc7, 0x02, 0x01, 0x91, 0x01}), WithExtRegistry(r)).Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if v, ok := obj.Value.(*MPObject); !ok || len(v.Child) != 1 || obj.FormatName != "nested" {
+		t.Errorf("nested mismatch. given=%s", obj)
+	}
+}
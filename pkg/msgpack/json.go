@@ -0,0 +1,351 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Values of JSONOptions.Keys.
+const (
+	JSONKeysString = "string" /* stringify scalar keys and use JSON text of array and map keys. e.g. {"1":2} */
+	JSONKeysJSON   = "json"   /* use JSON text of every key. e.g. {"$json_keys":{"1":2,"\"a\"":3}} */
+	JSONKeysPairs  = "pairs"  /* an array of key-value pairs. e.g. {"$pairs":[[1,2],["a",3]]} */
+)
+
+// JSONOptions changes the conversion of WriteJSON.
+type JSONOptions struct {
+	Keys      string                          /* how to show map which has keys other than str. empty means JSONKeysString */
+	Value     func(obj *MPObject) string      /* returns JSON of obj which is not array or map. nil means the one of MarshalJSON */
+	Duplicate func(obj *MPObject, key string) /* called with map and the JSON of the key if its keys are converted into the same key. e.g. 1 and "1" */
+}
+
+// MarshalJSON implements json.Marshaler. It returns obj as raw JSON like msgpack2json -r.
+//   - str which is not valid UTF-8 is shown with U+FFFD.
+//   - NaN and infinity of float are null.
+//   - bin and ext which is not decoded are hex like "0xdeadbeef".
+//   - time is {"sec":...,"nsec":...}.
+//   - keys of map other than str are converted into string. e.g. {"1":2}
+//     It can not be converted back and keys like 1 and "1" become the same key.
+//
+// Use package render for the other policies and verbose JSON.
+func (obj *MPObject) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSON(buf, obj, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteJSON writes obj and its children to w as raw JSON. nil opts is the same as MarshalJSON.
+//
+// A key of JSON object must be string, so the key of array or map is the JSON text of it.
+// In the text, map which has a key of array or map is shown as {"$pairs":...}.
+// Otherwise the nested keys are quoted again at each level and the text grows exponentially.
+//
+// Array and map which have fewer elements than the length, e.g. the ones of truncated input, are not output.
+func WriteJSON(w io.Writer, obj *MPObject, opts *JSONOptions) error {
+	if opts == nil {
+		opts = &JSONOptions{}
+	}
+	e := &jsonEncoder{out: bufio.NewWriter(w), opts: opts}
+	Traverse(obj, e.visit)
+	return e.out.Flush()
+}
+
+// jsonFrame is a container being output.
+type jsonFrame struct {
+	keys string          /* JSONKeysJSON or JSONKeysPairs if the policy is applied to the keys of map */
+	key  bool            /* the container is a key and its text is quoted when it is closed */
+	seen map[string]bool /* keys written to JSON object converted from keys other than str. nil if they are not checked */
+}
+
+// jsonEncoder keeps the containers and the text of the key being output.
+type jsonEncoder struct {
+	out   *bufio.Writer
+	opts  *JSONOptions
+	stack []jsonFrame
+	key   *strings.Builder /* text of array or map key. nil if it is not being output */
+	skip  int              /* nesting level in a broken container, which is not output */
+}
+
+// writer returns where the object being output is written.
+func (e *jsonEncoder) writer() io.StringWriter {
+	if e.key != nil {
+		return e.key
+	}
+	return e.out
+}
+
+func (e *jsonEncoder) value(obj *MPObject) string {
+	if e.opts.Value != nil {
+		return e.opts.Value(obj)
+	}
+	return jsonValue(obj)
+}
+
+// visit outputs an object which Traverse visits.
+func (e *jsonEncoder) visit(v Visit) {
+	obj := v.Obj
+	isCollection := IsArray(obj.FirstByte) || IsMap(obj.FirstByte)
+	if e.skip > 0 {
+		if v.Leave {
+			e.skip--
+		} else if isCollection {
+			e.skip++
+		}
+		return
+	}
+	if v.Leave {
+		e.close(v)
+		return
+	}
+
+	isKey, asJSON := false, false
+	if v.Parent != nil {
+		p := e.stack[len(e.stack)-1]
+		e.separate(v, p)
+		asJSON = p.keys == JSONKeysJSON
+		isKey = IsMap(v.Parent.FirstByte) && v.Index%2 == 0 && (asJSON || p.keys == "" && !IsString(obj.FirstByte))
+	}
+	if IsArray(obj.FirstByte) && len(obj.Child) < int(obj.Length) || IsMap(obj.FirstByte) && len(obj.Child) < int(obj.Length)*2 {
+		/* the broken container is not output, so the JSON does not look complete */
+		e.skip = 1
+		return
+	}
+
+	w := e.writer()
+	if isKey && !isCollection {
+		s := e.value(obj)
+		if asJSON || !strings.HasPrefix(s, `"`) {
+			/* the key whose JSON is string, e.g. bin, is used as it is unless the policy is JSONKeysJSON */
+			s = QuoteJSON(s, false)
+		}
+		e.checkKey(v.Parent, s)
+		w.WriteString(s)
+		return
+	} else if isKey {
+		/* keys in the text are not array or map key, since the map which has them is shown as pairs */
+		e.key = &strings.Builder{}
+		w = e.key
+	}
+
+	keys := ""
+	if hasNonStrKey(obj) && (e.opts.Keys == JSONKeysJSON || e.opts.Keys == JSONKeysPairs) {
+		keys = e.opts.Keys
+	}
+	if e.key != nil && hasCollectionKey(obj) {
+		keys = JSONKeysPairs
+	}
+	switch {
+	case keys == JSONKeysJSON:
+		/* the tag records the policy to convert the keys back */
+		w.WriteString(`{"$json_keys":{`)
+	case keys == JSONKeysPairs:
+		w.WriteString(`{"$pairs":[`)
+	case IsMap(obj.FirstByte):
+		w.WriteString("{")
+	case IsArray(obj.FirstByte):
+		w.WriteString("[")
+	default:
+		s := e.value(obj)
+		if v.Parent != nil && IsMap(v.Parent.FirstByte) && v.Index%2 == 0 {
+			/* str key */
+			e.checkKey(v.Parent, s)
+		}
+		w.WriteString(s)
+	}
+	if isCollection {
+		f := jsonFrame{keys: keys, key: isKey}
+		if e.opts.Duplicate != nil && keys != JSONKeysPairs && hasNonStrKey(obj) {
+			f.seen = map[string]bool{}
+		}
+		e.stack = append(e.stack, f)
+	}
+}
+
+// checkKey reports the key of map if it is already written.
+func (e *jsonEncoder) checkKey(m *MPObject, key string) {
+	f := e.stack[len(e.stack)-1]
+	if f.seen == nil {
+		return
+	}
+	if f.seen[key] {
+		e.opts.Duplicate(m, key)
+	}
+	f.seen[key] = true
+}
+
+// separate outputs the separator before an element of the container p.
+func (e *jsonEncoder) separate(v Visit, p jsonFrame) {
+	w := e.writer()
+	switch {
+	case p.keys == JSONKeysPairs && v.Index%2 == 1:
+		w.WriteString(",")
+	case p.keys == JSONKeysPairs && v.Index > 0:
+		/* close the previous pair */
+		w.WriteString("],[")
+	case p.keys == JSONKeysPairs:
+		w.WriteString("[")
+	case IsMap(v.Parent.FirstByte) && v.Index%2 == 1:
+		w.WriteString(":")
+	case v.Index > 0:
+		w.WriteString(",")
+	}
+}
+
+// close outputs the closing bracket of the container which Traverse leaves.
+func (e *jsonEncoder) close(v Visit) {
+	obj := v.Obj
+	f := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	w := e.writer()
+	switch {
+	case f.keys == JSONKeysPairs:
+		/* close the last pair. the map has at least one pair, since a key is not str */
+		w.WriteString("]]}")
+	case f.keys == JSONKeysJSON:
+		w.WriteString("}}")
+	case IsMap(obj.FirstByte):
+		w.WriteString("}")
+	default:
+		w.WriteString("]")
+	}
+	if f.key {
+		/* the text of the key is complete */
+		s := QuoteJSON(e.key.String(), false)
+		e.key = nil
+		e.checkKey(v.Parent, s)
+		e.out.WriteString(s)
+	}
+}
+
+// hasNonStrKey reports whether obj is map which has a key other than str.
+func hasNonStrKey(obj *MPObject) bool {
+	if !IsMap(obj.FirstByte) {
+		return false
+	}
+	for i := 0; i+1 < len(obj.Child); i += 2 {
+		if !IsString(obj.Child[i].FirstByte) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCollectionKey reports whether obj is map which has a key of array or map.
+func hasCollectionKey(obj *MPObject) bool {
+	if !IsMap(obj.FirstByte) {
+		return false
+	}
+	for i := 0; i+1 < len(obj.Child); i += 2 {
+		if IsArray(obj.Child[i].FirstByte) || IsMap(obj.Child[i].FirstByte) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValue returns obj which is not array or map as raw JSON.
+func jsonValue(obj *MPObject) string {
+	switch {
+	case IsString(obj.FirstByte), IsBin(obj.FirstByte):
+		return QuoteJSON(obj.DataStr, false)
+	case IsExt(obj.FirstByte):
+		switch v := obj.Value.(type) {
+		case nil, Ext:
+			return QuoteJSON(obj.DataStr, false)
+		case time.Time:
+			return fmt.Sprintf(`{"sec":%d,"nsec":%d}`, v.Unix(), v.Nanosecond())
+		case *MPObject:
+			/* nested MessagePack */
+			b := &strings.Builder{}
+			WriteJSON(b, v, nil)
+			return b.String()
+		}
+		b, err := json.Marshal(obj.Value)
+		if err != nil {
+			return QuoteJSON(obj.DataStr, false)
+		}
+		return string(b)
+	case obj.FirstByte == NilFormat || obj.FirstByte == NeverUsedFormat:
+		return "null"
+	}
+	if v, ok := obj.Float(); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		/* JSON can not represent NaN and infinity */
+		return "null"
+	}
+	return obj.DataStr
+}
+
+// QuoteJSON returns s as JSON string escaped according to RFC 8259.
+// A sequence of invalid UTF-8 bytes is replaced with U+FFFD, or with \ufffd escape sequence if escapeInvalid is true.
+func QuoteJSON(s string, escapeInvalid bool) string {
+	b := &strings.Builder{}
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	invalid := false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r == utf8.RuneError && size == 1 {
+			if !invalid {
+				if escapeInvalid {
+					b.WriteString(`\ufffd`)
+				} else {
+					b.WriteRune(utf8.RuneError)
+				}
+			}
+			invalid = true
+			continue
+		}
+		invalid = false
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				/* other control characters must be escaped */
+				fmt.Fprintf(b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
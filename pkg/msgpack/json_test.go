@@ -0,0 +1,262 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *MPObject
+		expected string
+	}
+
+	cases := []testcase{
+		{"nil", NewNil(), `null`},
+		{"bool", NewBool(true), `true`},
+		{"int", NewInt(-1), `-1`},
+		{"uint 64", NewUint(math.MaxUint64), `18446744073709551615`},
+		{"float", NewFloat64(0.1), `0.1`},
+		{"NaN", NewFloat64(math.NaN()), `null`},
+		{"infinity", NewFloat32(float32(math.Inf(-1))), `null`},
+		{"str", NewStr("a\"\n\x00"), `"a\"\n\u0000"`},
+		{"invalid UTF-8", NewStr("A\xff\xfeB"), "\"A\ufffdB\""},
+		{"bin", NewBin([]byte{0xde, 0xad}), `"0xdead"`},
+		{"ext", NewExt(1, []byte{0xde, 0xad}), `"0xdead"`},
+		{"timestamp", NewTimestamp(time.Unix(1, 5)), `{"sec":1,"nsec":5}`},
+		{"array", NewArray([]*MPObject{NewInt(1), NewArray(nil), NewMap(nil)}), `[1,[],{}]`},
+		{"map", NewMap([]*MPObject{NewStr("a"), NewInt(1), NewStr("b"), NewNil()}), `{"a":1,"b":null}`},
+		{"int key", NewMap([]*MPObject{NewInt(1), NewInt(2)}), `{"1":2}`},
+		{"bin key", NewMap([]*MPObject{NewBin([]byte{0xff}), NewInt(2)}), `{"0xff":2}`},
+		{"array key", NewMap([]*MPObject{NewArray([]*MPObject{NewStr("a")}), NewInt(2)}), `{"[\"a\"]":2}`},
+	}
+
+	for _, v := range cases {
+		ret, err := v.obj.MarshalJSON()
+		if err != nil {
+			t.Errorf("%s: MarshalJSON error %s", v.casename, err)
+			continue
+		}
+		if string(ret) != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, ret, v.expected)
+		}
+		if !json.Valid(ret) {
+			t.Errorf("%s: invalid JSON %s", v.casename, ret)
+		}
+	}
+}
+
+func TestQuoteJSON(t *testing.T) {
+	type testcase struct {
+		casename      string
+		str           string
+		escapeInvalid bool
+		expected      string
+	}
+
+	cases := []testcase{
+		{"empty", "", false, `""`},
+		{"ascii", "AB", false, `"AB"`},
+		{"quote", `say "hi"`, false, `"say \"hi\""`},
+		{"backslash", `C:\tmp`, false, `"C:\\tmp"`},
+		{"newline", "a\nb\r\n", false, `"a\nb\r\n"`},
+		{"tab", "a\tb", false, `"a\tb"`},
+		{"backspace and form feed", "\b\f", false, `"\b\f"`},
+		{"control", "\x00\x01\x1f", false, `"\u0000\u0001\u001f"`},
+		{"del", "\x7f", false, "\"\x7f\""},
+		{"slash", "a/b", false, `"a/b"`},
+		{"multibyte", "こんにちは", false, `"こんにちは"`},
+		{"invalid", "A\xff\xfeB", false, "\"A\ufffdB\""},
+		{"invalid escape", "A\xff\xfeB", true, `"A\ufffdB"`},
+		{"invalid and quote", "\xff\"", false, "\"\ufffd\\\"\""},
+	}
+
+	for _, v := range cases {
+		ret := QuoteJSON(v.str, v.escapeInvalid)
+		if ret != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, ret, v.expected)
+		}
+		if !json.Valid([]byte(ret)) {
+			t.Errorf("%s: invalid JSON %s", v.casename, ret)
+		}
+	}
+}
+
+func TestWriteJSONNestedKeys(t *testing.T) {
+	type testcase struct {
+		casename string
+		keys     string
+		expected string
+	}
+
+	/* {{{1:1}:1}:1} */
+	in := []byte{0x81, 0x81, 0x81, 0x01, 0x01, 0x01, 0x01}
+	cases := []testcase{
+		{"string", "", `{"{\"$pairs\":[[{\"1\":1},1]]}":1}`},
+		{"json", JSONKeysJSON, `{"$json_keys":{"{\"$pairs\":[[{\"$json_keys\":{\"1\":1}},1]]}":1}}`},
+		{"pairs", JSONKeysPairs, `{"$pairs":[[{"$pairs":[[{"$pairs":[[1,1]]},1]]},1]]}`},
+	}
+
+	/* keys nested 30 deep. quoting the nested keys again doubles the output at each level. */
+	depth := 30
+	deep, err := Decode(bytes.NewBuffer(append(bytes.Repeat([]byte{0x81}, depth), bytes.Repeat([]byte{0x01}, depth+1)...)))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer(in))
+		if err != nil {
+			t.Fatalf("Decode error %s", err)
+		}
+		buf.Reset()
+		if err := WriteJSON(&buf, obj, &JSONOptions{Keys: v.keys}); err != nil {
+			t.Errorf("%s: WriteJSON error %s", v.casename, err)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, buf.String(), v.expected)
+		}
+
+		buf.Reset()
+		if err := WriteJSON(&buf, deep, &JSONOptions{Keys: v.keys}); err != nil {
+			t.Errorf("%s: WriteJSON error %s", v.casename, err)
+		}
+		if buf.Len() > 20*depth {
+			t.Errorf("%s: %d bytes for depth %d", v.casename, buf.Len(), depth)
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("%s: invalid JSON %s", v.casename, buf.String())
+		}
+	}
+}
+
+func TestWriteJSONBroken(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		expected string
+	}
+
+	cases := []testcase{
+		{"array", []byte{0x92, 0x01}, ""},
+		{"map", []byte{0x82, 0x01, 0x02, 0x03}, ""},
+		{"nested", []byte{0x92, 0x01, 0x92, 0x02}, "[1,]"},
+		{"nested deeply", []byte{0x81, 0xa1, 0x61, 0x92, 0x91, 0x01, 0x92, 0x02}, `{"a":[[1],]}`},
+	}
+
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer(v.bytes))
+		if err == nil {
+			t.Errorf("%s: no error", v.casename)
+		}
+		ret, _ := json.Marshal(obj)
+		buf := bytes.Buffer{}
+		if err := WriteJSON(&buf, obj, nil); err != nil {
+			t.Errorf("%s: WriteJSON error %s", v.casename, err)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, buf.String(), v.expected)
+		}
+		if ret != nil {
+			/* the JSON does not look complete */
+			t.Errorf("%s: json.Marshal returns %s", v.casename, ret)
+		}
+	}
+}
+
+func TestWriteJSONDuplicate(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *MPObject
+		keys     string
+		expected []string /* duplicated keys */
+	}
+
+	num := NewMap([]*MPObject{NewInt(1), NewStr("a"), NewStr("1"), NewStr("b")})
+	formats := NewMap([]*MPObject{NewInt(1), NewStr("a"), NewUint(1), NewStr("b")})
+	array := NewMap([]*MPObject{NewArray([]*MPObject{NewInt(1)}), NewStr("a"), NewStr("[1]"), NewStr("b")})
+	cases := []testcase{
+		{"string", num, "", []string{`"1"`}},
+		{"json", num, JSONKeysJSON, []string{}},
+		{"pairs", num, JSONKeysPairs, []string{}},
+		{"formats", formats, JSONKeysString, []string{`"1"`}},
+		{"formats json", formats, JSONKeysJSON, []string{`"1"`}},
+		{"array", array, "", []string{`"[1]"`}},
+		{"nested", NewArray([]*MPObject{NewStr("x"), num}), "", []string{`"1"`}},
+		{"different", NewMap([]*MPObject{NewInt(1), NewStr("a"), NewInt(2), NewStr("b")}), "", []string{}},
+		{"str keys", NewMap([]*MPObject{NewStr("a"), NewInt(1), NewStr("a"), NewInt(2)}), "", []string{}},
+	}
+
+	for _, v := range cases {
+		given := []string{}
+		opts := &JSONOptions{Keys: v.keys, Duplicate: func(obj *MPObject, key string) {
+			if !IsMap(obj.FirstByte) {
+				t.Errorf("%s: not map %s", v.casename, obj.DataStr)
+			}
+			given = append(given, key)
+		}}
+		if err := WriteJSON(ioutil.Discard, v.obj, opts); err != nil {
+			t.Errorf("%s: WriteJSON error %s", v.casename, err)
+		}
+		if !reflect.DeepEqual(given, v.expected) {
+			t.Errorf("%s: mismatch. given: %q expected: %q", v.casename, given, v.expected)
+		}
+	}
+}
+
+func TestMarshalJSONEmbedded(t *testing.T) {
+	/* MPObject in a struct is converted by json.Marshal */
+	obj, err := Decode(bytes.NewBuffer([]byte{0x92, 0xa3, 0x74, 0x61, 0x67, 0x81, 0xa1, 0x6b, 0x01}))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	ret, err := json.Marshal(struct {
+		Record *MPObject `json:"record"`
+	}{obj})
+	if err != nil {
+		t.Fatalf("json.Marshal error %s", err)
+	}
+	if string(ret) != `{"record":["tag",{"k":1}]}` {
+		t.Errorf("mismatch. given: %s", ret)
+	}
+}
+
+func TestMarshalJSONDeepNesting(t *testing.T) {
+	/* [[[...[1]...]]] */
+	depth := 100000
+	obj, err := Decode(bytes.NewBuffer(append(bytes.Repeat([]byte{0x91}, depth), 0x01)))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	ret, err := obj.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON error %s", err)
+	}
+	if string(ret) != strings.Repeat("[", depth)+"1"+strings.Repeat("]", depth) {
+		t.Errorf("mismatch")
+	}
+}
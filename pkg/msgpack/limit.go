@@ -0,0 +1,74 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"fmt"
+)
+
+// Limits restricts resources which Decoder uses for untrusted input.
+// Zero means no limit.
+type Limits struct {
+	MaxDepth  int   /* nesting depth of array and map. e.g. [[1]] has depth 2 */
+	MaxLength int   /* number of elements of array and key-value pairs of map */
+	MaxSize   int   /* payload size of str, bin and ext in bytes */
+	MaxBytes  int64 /* total bytes which the decoder reads */
+}
+
+// WithLimits makes the decoder report an error if the input exceeds l.
+// The error is *DecodeError and errors.Is reports ErrLimitExceeded and
+// one of ErrDepthLimit, ErrLengthLimit, ErrSizeLimit and ErrBytesLimit.
+func WithLimits(l Limits) DecoderOption {
+	return func(d *Decoder) {
+		d.limits = l
+	}
+}
+
+// checkDepth checks the depth of container obj before decoding its children.
+func (d *Decoder) checkDepth(obj *MPObject) error {
+	if max := d.limits.MaxDepth; max > 0 && len(d.path)+1 > max {
+		return d.newError(ErrDepthLimit, obj, 0, 0, fmt.Errorf("depth %d exceeds %d", len(d.path)+1, max))
+	}
+	return nil
+}
+
+// checkLength checks the number of elements of container obj before allocating its children.
+func (d *Decoder) checkLength(obj *MPObject) error {
+	if max := d.limits.MaxLength; max > 0 && int64(obj.Length) > int64(max) {
+		return d.newError(ErrLengthLimit, obj, 0, 0, fmt.Errorf("length %d exceeds %d", obj.Length, max))
+	}
+	return nil
+}
+
+// checkBytes checks whether the decoder can read n bytes from offset.
+func (d *Decoder) checkBytes(obj *MPObject, offset int64, n int) error {
+	if max := d.limits.MaxBytes; max > 0 && offset+int64(n) > max {
+		return d.newError(ErrBytesLimit, obj, 0, 0, fmt.Errorf("%d bytes at offset %d exceed %d", n, offset, max))
+	}
+	return nil
+}
+
+// payload reads n bytes of the payload of str, bin or ext.
+func (d *Decoder) payload(obj *MPObject, n int) ([]byte, error) {
+	if max := d.limits.MaxSize; max > 0 && n > max {
+		return nil, d.newError(ErrSizeLimit, obj, 0, 0, fmt.Errorf("size %d exceeds %d", n, max))
+	}
+	if d.discard {
+		return nil, d.skip(obj, n)
+	}
+	return d.next(obj, n)
+}
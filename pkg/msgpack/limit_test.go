@@ -0,0 +1,122 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		limits   Limits
+		kind     error
+		offset   int64
+		path     string
+	}
+
+	/* [[[1]]] */
+	nested := []byte{0x91, 0x91, 0x91, 0x01}
+	/* {"A":[1,2,3]} */
+	fixmap := []byte{0x81, 0xa1, 0x41, 0x93, 0x01, 0x02, 0x03}
+
+	cases := []testcase{
+		{"depth ok", nested, Limits{MaxDepth: 3}, nil, 0, ""},
+		{"depth", nested, Limits{MaxDepth: 2}, ErrDepthLimit, 3, "$[0][0]"},
+		{"depth map", fixmap, Limits{MaxDepth: 1}, ErrDepthLimit, 4, "$.A"},
+		{"length ok", fixmap, Limits{MaxLength: 3}, nil, 0, ""},
+		{"length", fixmap, Limits{MaxLength: 2}, ErrLengthLimit, 4, "$.A"},
+		{"array32 header", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, Limits{MaxLength: 1024}, ErrLengthLimit, 5, "$"},
+		{"map32 header", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, Limits{MaxLength: 1024}, ErrLengthLimit, 5, "$"},
+		{"size ok", []byte{0xa3, 0x41, 0x42, 0x43}, Limits{MaxSize: 3}, nil, 0, ""},
+		{"fixstr", []byte{0xa3, 0x41, 0x42, 0x43}, Limits{MaxSize: 2}, ErrSizeLimit, 1, "$"},
+		{"str32 header", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, Limits{MaxSize: 1024}, ErrSizeLimit, 5, "$"},
+		{"bin8", []byte{0xc4, 0x02, 0x41, 0x42}, Limits{MaxSize: 1}, ErrSizeLimit, 2, "$"},
+		{"fixext", []byte{0xd5, 0x01, 0x41, 0x42}, Limits{MaxSize: 1}, ErrSizeLimit, 2, "$"},
+		{"ext8", []byte{0xc7, 0x02, 0x01, 0x41, 0x42}, Limits{MaxSize: 1}, ErrSizeLimit, 3, "$"},
+		{"bytes ok", fixmap, Limits{MaxBytes: 7}, nil, 0, ""},
+		{"bytes", fixmap, Limits{MaxBytes: 6}, ErrBytesLimit, 7, "$.A[2]"},
+		{"bytes payload", []byte{0xa3, 0x41, 0x42, 0x43}, Limits{MaxBytes: 3}, ErrBytesLimit, 1, "$"},
+		{"no limit", fixmap, Limits{}, nil, 0, ""},
+	}
+
+	for _, v := range cases {
+		_, err := NewDecoder(bytes.NewReader(v.bytes), WithLimits(v.limits)).Decode()
+		if v.kind == nil {
+			if err != nil {
+				t.Errorf("%s: err=%v", v.casename, err)
+			}
+			continue
+		}
+		if !errors.Is(err, v.kind) || !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: kind mismatch. err=%v", v.casename, err)
+			continue
+		}
+		for _, k := range []error{ErrDepthLimit, ErrLengthLimit, ErrSizeLimit, ErrBytesLimit} {
+			if k != v.kind && errors.Is(err, k) {
+				t.Errorf("%s: %v should not match %v", v.casename, err, k)
+			}
+		}
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: not DecodeError. err=%v", v.casename, err)
+			continue
+		}
+		if e.Offset != v.offset || e.Path != v.path {
+			t.Errorf("%s: mismatch. given: offset=%d path=%s expected: offset=%d path=%s", v.casename, e.Offset, e.Path, v.offset, v.path)
+		}
+	}
+}
+
+func TestLimitsStream(t *testing.T) {
+	/* MaxBytes is the total of the stream */
+	dec := NewDecoder(bytes.NewReader([]byte{0x01, 0x02, 0x03}), WithLimits(Limits{MaxBytes: 2}))
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatalf("%d: err=%v", i, err)
+		}
+	}
+	if _, err := dec.Decode(); !errors.Is(err, ErrBytesLimit) {
+		t.Errorf("ErrBytesLimit is not returned. err=%v", err)
+	}
+
+	/* the input which ends at the limit */
+	dec = NewDecoder(bytes.NewReader([]byte{0x01, 0x02}), WithLimits(Limits{MaxBytes: 2}))
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatalf("%d: err=%v", i, err)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("io.EOF is not returned. err=%v", err)
+	}
+}
+
+func TestHugeLengthHeader(t *testing.T) {
+	/* array 32 with 0xffffffff elements must not allocate them in advance */
+	obj, err := Decode(bytes.NewBuffer([]byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}))
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("ErrTruncated is not returned. err=%v", err)
+	}
+	if obj == nil || len(obj.Child) != 1 || cap(obj.Child) > maxPreallocChildren {
+		t.Errorf("children mismatch. given: %v", obj)
+	}
+}
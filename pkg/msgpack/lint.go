@@ -0,0 +1,288 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Severity represents how serious a Finding is.
+type Severity int
+
+// Severities of Finding.
+const (
+	// SeverityInfo means the encoding is legal but can be improved.
+	SeverityInfo Severity = iota
+	// SeverityWarning means the encoding is legal but suggests a broken serializer.
+	SeverityWarning
+	// SeverityError means the encoding violates the spec.
+	SeverityError
+)
+
+// String implements Stringer interface.
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Finding describes a suspicious encoding reported by Lint.
+type Finding struct {
+	Severity Severity
+	Offset   int64  /* position of the object in the input */
+	Path     string /* path of the object. e.g. $[3].tags["host"] */
+	Format   string /* format name of the object */
+	Message  string
+}
+
+// String implements Stringer interface.
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: offset %d, path %s, format %s: %s", f.Severity, f.Offset, f.Path, f.Format, f.Message)
+}
+
+// Lint checks obj and its children and reports legal but suspicious encodings.
+// Findings are ordered by the position of the objects.
+//
+// It reports
+//
+//	non-minimal integer and length encodings (warning)
+//	float 64 values which are exactly representable as float 32 (info)
+//	0xc1 which is never used (error)
+//	str which is not valid UTF-8 (error)
+//	reserved ext types -128 to -2 (warning)
+//	timestamp ext with invalid size or nanoseconds above 999999999 (error)
+func Lint(obj *MPObject) []Finding {
+	l := &linter{}
+	l.lint(obj)
+	return l.findings
+}
+
+type linter struct {
+	path     []pathElem
+	findings []Finding
+}
+
+func (l *linter) report(obj *MPObject, s Severity, format string, a ...interface{}) {
+	l.findings = append(l.findings, Finding{
+		Severity: s,
+		Offset:   obj.Offset,
+		Path:     formatPath(l.path),
+		Format:   obj.FormatName,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (l *linter) lint(obj *MPObject) {
+	if obj == nil {
+		return
+	}
+
+	switch {
+	case obj.FirstByte == NeverUsedFormat:
+		l.report(obj, SeverityError, "0x%02x is never used", obj.FirstByte)
+	case IsArray(obj.FirstByte) || IsMap(obj.FirstByte):
+		l.lintLength(obj)
+		l.path = append(l.path, pathElem{obj: obj})
+		for i, v := range obj.Child {
+			elem := &l.path[len(l.path)-1]
+			elem.index = i
+			if IsMap(obj.FirstByte) && i%2 == 1 {
+				elem.key = obj.Child[i-1]
+			}
+			l.lint(v)
+		}
+		l.path = l.path[:len(l.path)-1]
+	case IsString(obj.FirstByte):
+		l.lintLength(obj)
+		l.lintUTF8(obj)
+	case IsBin(obj.FirstByte):
+		l.lintLength(obj)
+	case IsExt(obj.FirstByte):
+		l.lintExt(obj)
+	case obj.FirstByte == Float64Format:
+		if v, ok := obj.Value.(float64); ok && float64(float32(v)) == v {
+			l.report(obj, SeverityInfo, "%s can be encoded as float 32 without loss", obj.DataStr)
+		}
+	default:
+		l.lintInt(obj)
+	}
+}
+
+// lintInt reports an integer which has a smaller format.
+func (l *linter) lintInt(obj *MPObject) {
+	var min *MPObject
+	if v, ok := obj.Uint(); ok {
+		min = NewUint(v)
+	} else if v, ok := obj.Int(); ok {
+		min = NewInteger(v)
+	} else {
+		return
+	}
+	if intSize(min.FirstByte) < intSize(obj.FirstByte) {
+		l.report(obj, SeverityWarning, "%s can be encoded as %s", obj.DataStr, min.FormatName)
+	}
+}
+
+// intSize returns the encoded size of integer format b.
+func intSize(b byte) int {
+	switch b {
+	case Uint8Format, Int8Format:
+		return 2
+	case Uint16Format, Int16Format:
+		return 3
+	case Uint32Format, Int32Format:
+		return 5
+	case Uint64Format, Int64Format:
+		return 9
+	}
+	return 1
+}
+
+// lintLength reports a str, bin, ext, array or map which has a smaller header.
+func (l *linter) lintLength(obj *MPObject) {
+	b := minLengthFormat(obj.FirstByte, obj.Length)
+	if headerSize(b) < headerSize(obj.FirstByte) {
+		l.report(obj, SeverityWarning, "length %d can be encoded as %s", obj.Length, formatName(b))
+	}
+}
+
+// minLengthFormat returns the smallest format of the same family as b for length.
+func minLengthFormat(b byte, length uint32) byte {
+	switch {
+	case IsString(b) && length <= 0x1f:
+		return 0xa0 | byte(length)
+	case IsArray(b) && length <= 0xf:
+		return 0x90 | byte(length)
+	case IsMap(b) && length <= 0xf:
+		return 0x80 | byte(length)
+	case IsExt(b):
+		switch length {
+		case 1:
+			return FixExt1Format
+		case 2:
+			return FixExt2Format
+		case 4:
+			return FixExt4Format
+		case 8:
+			return FixExt8Format
+		case 16:
+			return FixExt16Format
+		}
+	}
+
+	/* index of 8, 16 and 32 bits length formats */
+	i := 0
+	if length > 0xffff {
+		i = 2
+	} else if length > 0xff {
+		i = 1
+	}
+	switch {
+	case IsString(b):
+		return []byte{Str8Format, Str16Format, Str32Format}[i]
+	case IsBin(b):
+		return []byte{Bin8Format, Bin16Format, Bin32Format}[i]
+	case IsExt(b):
+		return []byte{Ext8Format, Ext16Format, Ext32Format}[i]
+	case IsArray(b):
+		return []byte{Array16Format, Array16Format, Array32Format}[i]
+	case IsMap(b):
+		return []byte{Map16Format, Map16Format, Map32Format}[i]
+	}
+	return b
+}
+
+// lintUTF8 reports str which is not valid UTF-8.
+func (l *linter) lintUTF8(obj *MPObject) {
+	if e := obj.InvalidUTF8; e != nil {
+		l.report(obj, SeverityError, "invalid UTF-8 at offset %d (payload index %d)", e.Offset, e.Index)
+	} else if s, ok := obj.Str(); ok {
+		if i := invalidUTF8Index(s); i >= 0 {
+			l.report(obj, SeverityError, "invalid UTF-8 at payload index %d", i)
+		}
+	}
+}
+
+func (l *linter) lintExt(obj *MPObject) {
+	data := obj.extData()
+	if isExt(obj.FirstByte) {
+		l.lintLength(obj)
+	}
+
+	switch {
+	case obj.ExtType == -1:
+		l.lintTimestamp(obj, data)
+	case obj.ExtType < -1:
+		l.report(obj, SeverityWarning, "ext type %d is reserved", obj.ExtType)
+	}
+
+	if obj.ExtType != -1 {
+		/* timestamp is checked above */
+		for _, v := range obj.Diagnostics {
+			var e *ExtError
+			if errors.As(v, &e) {
+				l.report(obj, SeverityError, "%s can not be decoded: %s", e.TypeName, e.Err)
+			}
+		}
+	}
+}
+
+// lintTimestamp checks the payload of timestamp ext.
+// data is nil if the payload is not available.
+func (l *linter) lintTimestamp(obj *MPObject, data []byte) {
+	var nsec uint32
+	switch {
+	case obj.FirstByte == FixExt4Format:
+		return
+	case obj.FirstByte == FixExt8Format:
+		if len(data) != 8 {
+			return
+		}
+		nsec = uint32(binary.BigEndian.Uint64(data) >> 34)
+	case obj.FirstByte == Ext8Format && obj.Length == 12:
+		if len(data) != 12 {
+			return
+		}
+		nsec = binary.BigEndian.Uint32(data[:4])
+	default:
+		l.report(obj, SeverityError, "timestamp must be 4, 8 or 12 bytes")
+		return
+	}
+	if nsec > 999999999 {
+		l.report(obj, SeverityError, "nanoseconds %d is larger than 999999999", nsec)
+	}
+}
+
+// extData returns the payload of ext family.
+// It returns nil if obj is not decoded from the input and the payload is unknown.
+func (obj *MPObject) extData() []byte {
+	if e, ok := obj.Value.(Ext); ok {
+		return e.Data
+	}
+	if obj.HeaderSize > 0 && len(obj.Raw) > obj.HeaderSize {
+		return obj.Raw[obj.HeaderSize:]
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLint(t *testing.T) {
+	type finding struct {
+		severity Severity
+		offset   int64
+		path     string
+	}
+	type testcase struct {
+		casename string
+		bytes    []byte
+		expected []finding
+	}
+
+	cases := []testcase{
+		{"positive fixint", []byte{0x05}, nil},
+		{"uint8", []byte{0xcc, 0x80}, nil},
+		{"uint8 small", []byte{0xcc, 0x05}, []finding{{SeverityWarning, 0, "$"}}},
+		{"uint16 small", []byte{0xcd, 0x00, 0xff}, []finding{{SeverityWarning, 0, "$"}}},
+		{"uint32", []byte{0xce, 0x00, 0x01, 0x00, 0x00}, nil},
+		{"uint64 small", []byte{0xcf, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}, []finding{{SeverityWarning, 0, "$"}}},
+		{"int8 negative", []byte{0xd0, 0xdf}, nil},
+		{"int8 fixint", []byte{0xd0, 0xff}, []finding{{SeverityWarning, 0, "$"}}},
+		{"int16 positive", []byte{0xd1, 0x00, 0xff}, []finding{{SeverityWarning, 0, "$"}}},
+		{"int16", []byte{0xd1, 0xff, 0x00}, nil},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, nil},
+		{"float64 fits float32", []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, []finding{{SeverityInfo, 0, "$"}}},
+		{"float64", []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, nil},
+		{"never used", []byte{0xc1}, []finding{{SeverityError, 0, "$"}}},
+		{"fixstr", []byte{0xa1, 0x41}, nil},
+		{"str8 short", []byte{0xd9, 0x01, 0x41}, []finding{{SeverityWarning, 0, "$"}}},
+		{"str32 short", []byte{0xdb, 0x00, 0x00, 0x00, 0x01, 0x41}, []finding{{SeverityWarning, 0, "$"}}},
+		{"bin8", []byte{0xc4, 0x01, 0x41}, nil},
+		{"bin16 short", []byte{0xc5, 0x00, 0x01, 0x41}, []finding{{SeverityWarning, 0, "$"}}},
+		{"array16 short", []byte{0xdc, 0x00, 0x01, 0xc0}, []finding{{SeverityWarning, 0, "$"}}},
+		{"map16 short", []byte{0xde, 0x00, 0x02, 0xa1, 0x41, 0x01, 0xa1, 0x42, 0xcd, 0x00, 0x01},
+			[]finding{{SeverityWarning, 0, "$"}, {SeverityWarning, 8, "$.B"}}},
+		{"nested", []byte{0x92, 0x01, 0x81, 0xa4, 0x74, 0x61, 0x67, 0x73, 0x91, 0xcc, 0x01}, []finding{{SeverityWarning, 9, "$[1].tags[0]"}}},
+		{"never used in array", []byte{0x92, 0xcc, 0x01, 0xc1}, []finding{{SeverityWarning, 1, "$[0]"}, {SeverityError, 3, "$[1]"}}},
+		{"never used twice", []byte{0x93, 0xc1, 0xc1, 0xcd, 0x00, 0x01},
+			[]finding{{SeverityError, 1, "$[0]"}, {SeverityError, 2, "$[1]"}, {SeverityWarning, 3, "$[2]"}}},
+		{"ext8 fixext size", []byte{0xc7, 0x01, 0x01, 0x41}, []finding{{SeverityWarning, 0, "$"}}},
+		{"ext8", []byte{0xc7, 0x03, 0x01, 0x41, 0x42, 0x43}, nil},
+		{"ext16 short", []byte{0xc8, 0x00, 0x03, 0x01, 0x41, 0x42, 0x43}, []finding{{SeverityWarning, 0, "$"}}},
+		{"reserved ext", []byte{0xd4, 0xfe, 0x00}, []finding{{SeverityWarning, 0, "$"}}},
+		{"reserved ext -128", []byte{0xd4, 0x80, 0x00}, []finding{{SeverityWarning, 0, "$"}}},
+		{"timestamp32", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, nil},
+		{"timestamp64", []byte{0xd7, 0xff, 0xee, 0x6b, 0x27, 0xfc, 0x00, 0x00, 0x00, 0x01}, nil},
+		{"timestamp64 nsec", []byte{0xd7, 0xff, 0xee, 0x6b, 0x28, 0x00, 0x00, 0x00, 0x00, 0x01}, []finding{{SeverityError, 0, "$"}}},
+		{"timestamp96", []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xc9, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, nil},
+		{"timestamp96 nsec", []byte{0xc7, 0x0c, 0xff, 0x3b, 0x9a, 0xca, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, []finding{{SeverityError, 0, "$"}}},
+		{"timestamp invalid size", []byte{0xd4, 0xff, 0x00}, []finding{{SeverityError, 0, "$"}}},
+		{"timestamp ext8 invalid size", []byte{0xc7, 0x04, 0xff, 0x00, 0x00, 0x00, 0x01}, []finding{{SeverityWarning, 0, "$"}, {SeverityError, 0, "$"}}},
+		{"user ext", []byte{0xd4, 0x01, 0x00}, nil},
+		{"invalid UTF-8", []byte{0xa2, 0x41, 0xff}, []finding{{SeverityError, 0, "$"}}},
+		{"str8 invalid UTF-8", []byte{0xd9, 0x01, 0xff}, []finding{{SeverityWarning, 0, "$"}, {SeverityError, 0, "$"}}},
+	}
+
+	/* str16 which fits str8 */
+	str16 := append([]byte{0xda, 0x00, 0xff}, []byte(strings.Repeat("A", 0xff))...)
+	cases = append(cases, testcase{"str16 short", str16, []finding{{SeverityWarning, 0, "$"}}})
+
+	for _, v := range cases {
+		obj, _ := Decode(bytes.NewBuffer(v.bytes))
+		ret := Lint(obj)
+		if len(ret) != len(v.expected) {
+			t.Errorf("%s: length mismatch. given: %v expected: %v", v.casename, ret, v.expected)
+			continue
+		}
+		for i, f := range ret {
+			e := v.expected[i]
+			if f.Severity != e.severity || f.Offset != e.offset || f.Path != e.path {
+				t.Errorf("%s: mismatch. given: %v expected: %v", v.casename, f, e)
+			}
+			if f.Message == "" || f.Format == "" {
+				t.Errorf("%s: empty message or format. given: %v", v.casename, f)
+			}
+		}
+	}
+}
+
+func TestLintBuilder(t *testing.T) {
+	obj := NewArray([]*MPObject{
+		NewInteger(-1),
+		NewUint(300),
+		NewFloat(1.5),
+		NewStr(strings.Repeat("A", 32)),
+		NewBin([]byte{0x01}),
+		NewMap([]*MPObject{NewStr("k"), NewNil()}),
+		NewTimestamp(time.Unix(1, 1)),
+		NewEventTime(time.Unix(1, 1)),
+		NewExt(1, []byte{0x01, 0x02, 0x03}),
+	})
+	if ret := Lint(obj); len(ret) != 0 {
+		t.Errorf("built objects should be minimal. given: %v", ret)
+	}
+
+	if ret := Lint(NewStr("\xff")); len(ret) != 1 || ret[0].Severity != SeverityError {
+		t.Errorf("invalid UTF-8: mismatch. given: %v", ret)
+	}
+
+	obj = &MPObject{FirstByte: Uint64Format, FormatName: "uint 64", Value: uint64(1), DataStr: "1"}
+	if ret := Lint(obj); len(ret) != 1 || ret[0].Severity != SeverityWarning {
+		t.Errorf("uint 64: mismatch. given: %v", ret)
+	}
+}
+
+func TestLintExtError(t *testing.T) {
+	r := NewExtRegistry()
+	r.RegisterFluentdEventTime()
+	/* event time with nanoseconds 1000000000 */
+	b := []byte{0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x3b, 0x9a, 0xca, 0x00}
+	obj, err := NewDecoder(bytes.NewReader(b), WithExtRegistry(r)).Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	ret := Lint(obj)
+	if len(ret) != 1 || ret[0].Severity != SeverityError || ret[0].Format != "event time" {
+		t.Errorf("mismatch. given: %v", ret)
+	}
+}
+
+func TestFindingString(t *testing.T) {
+	f := Finding{Severity: SeverityWarning, Offset: 8, Path: "$.schema", Format: "uint 32", Message: "5 can be encoded as positive fixint"}
+	expected := "warning: offset 8, path $.schema, format uint 32: 5 can be encoded as positive fixint"
+	if f.String() != expected {
+		t.Errorf("mismatch. given: %s expected: %s", f.String(), expected)
+	}
+}
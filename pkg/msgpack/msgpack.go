@@ -17,9 +17,12 @@
 package msgpack
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 )
 
 // First Byte of each format.
@@ -86,6 +89,27 @@ func isExt(b byte) bool {
 	return (b >= 0xc7 && b <= 0xc9)
 }
 
+// headerSize returns the size of the header which starts with b.
+// The header consists of the first byte, the length field and the ext type.
+func headerSize(b byte) int {
+	switch b {
+	case Bin8Format, Str8Format:
+		return 2
+	case Bin16Format, Str16Format, Array16Format, Map16Format, Ext8Format:
+		return 3
+	case Ext16Format:
+		return 4
+	case Bin32Format, Str32Format, Array32Format, Map32Format:
+		return 5
+	case Ext32Format:
+		return 6
+	}
+	if isFixExt(b) {
+		return 2
+	}
+	return 1
+}
+
 // IsArray reports whether the byte is array format family header.
 func IsArray(b byte) bool {
 	return (isFixArray(b) || b == Array16Format || b == Array32Format)
@@ -183,14 +207,34 @@ func typeStr(b byte) string {
 
 // MPObject represents message pack object.
 // If the object is array or map, MPObject has Child which represents each element.
+//
+// Value holds the typed value of the object and it is one of the following types.
+//
+//	nil:       nil, (never used), map and array family
+//	bool:      true and false
+//	int64:     positive fixint, negative fixint and int family
+//	uint64:    uint family
+//	float32:   float 32
+//	float64:   float 64
+//	string:    str family
+//	[]byte:    bin family
+//	time.Time: timestamp ext and Fluentd event time ext
+//	Ext:       other ext family
 type MPObject struct {
 	FirstByte  byte
 	FormatName string
-	ExtType    int8   /* for ext family*/
-	Length     uint32 /* for map, array and str family*/
-	DataStr    string
-	Raw        []byte
+	ExtType    int8        /* for ext family*/
+	Length     uint32      /* for map, array and str family*/
+	DataStr    string      /* string representation of Value */
+	Value      interface{} /* typed value. use SetValue to edit it with DataStr */
+	Raw        []byte      /* encoded bytes. it shares memory with the parent's Raw */
 	Child      []*MPObject
+	Offset     int64 /* position of FirstByte in the input */
+	HeaderSize int   /* size of FirstByte, length field and ext type */
+	Size       int64 /* total encoded size including children */
+
+	InvalidUTF8 *UTF8Error /* for str family. non-nil if the payload is not valid UTF-8 */
+	Diagnostics []error    /* problems which do not stop decoding. e.g. *ExtError, ErrNeverUsed */
 }
 
 // String implements Stringer interface.
@@ -207,24 +251,50 @@ func (obj *MPObject) String() string {
 	}
 }
 
-func nextWithError(buf *bytes.Buffer, n int) ([]byte, error) {
-	bufs := buf.Next(n)
-	if len(bufs) != n {
-		return bufs, fmt.Errorf("bytes.Buffer Next error")
+// readChunkSize is the initial capacity used to read variable length data.
+// Larger payloads grow as data actually arrives, so a broken length header
+// does not allocate memory in advance.
+const readChunkSize = 64 * 1024
+
+// nextWithError reads exactly n bytes from r.
+// It returns io.ErrUnexpectedEOF if r ends before n bytes are read.
+func nextWithError(r io.Reader, n int) ([]byte, error) {
+	return appendWithError(nil, r, n)
+}
+
+// appendWithError reads exactly n bytes from r and appends them to dst.
+// dst grows by readChunkSize at most, as data actually arrives.
+// It returns io.ErrUnexpectedEOF if r ends before n bytes are read.
+func appendWithError(dst []byte, r io.Reader, n int) ([]byte, error) {
+	for n > 0 {
+		chunk := n
+		if chunk > readChunkSize {
+			chunk = readChunkSize
+		}
+		l := len(dst)
+		dst = append(dst, make([]byte, chunk)...)
+		read, err := io.ReadFull(r, dst[l:])
+		dst = dst[:l+read]
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return dst, err
+		}
+		n -= chunk
 	}
-	return bufs, nil
+	return dst, nil
 }
 
-func (obj *MPObject) setLengthFromBytes(size int, buf *bytes.Buffer) error {
+func (obj *MPObject) setLengthFromBytes(size int, d *Decoder) error {
 	if size != 1 && size != 2 && size != 4 {
 		return fmt.Errorf("illegal size %d", size)
 	}
 
-	length, err := nextWithError(buf, size)
+	length, err := d.next(obj, size)
 	if err != nil {
 		return err
 	}
-	obj.Raw = append(obj.Raw, length...)
 
 	switch size {
 	case 1:
@@ -237,56 +307,332 @@ func (obj *MPObject) setLengthFromBytes(size int, buf *bytes.Buffer) error {
 	return nil
 }
 
-func (obj *MPObject) setNum(size int, buf *bytes.Buffer, conv func([]byte) string) error {
-	bufs, err := nextWithError(buf, size)
+func (obj *MPObject) setNum(size int, d *Decoder, conv func([]byte) interface{}) error {
+	bufs, err := d.next(obj, size)
 	if err != nil {
 		return err
 	}
-	obj.Raw = append(obj.Raw, bufs...)
-	obj.DataStr = conv(bufs)
+	obj.SetValue(conv(bufs))
 
 	return nil
 }
 
-func (obj *MPObject) setCollection(buf *bytes.Buffer, length int) error {
-	obj.Child = make([]*MPObject, length)
+// maxPreallocChildren is the maximum capacity of MPObject.Child allocated in advance.
+// A broken length header does not allocate a huge slice before its children arrive.
+const maxPreallocChildren = 1024
 
-	for i := 0; i < length; i++ {
-		mpobj, err := Decode(buf)
-		if err != nil {
-			return err
+// setCollection checks the length of obj before its children are decoded.
+// The children are read by Decoder.readToken.
+func (obj *MPObject) setCollection(d *Decoder) error {
+	if err := d.checkDepth(obj); err != nil {
+		return err
+	}
+	return d.checkLength(obj)
+}
+
+// numChildren returns the number of children which obj should have.
+func numChildren(obj *MPObject) int {
+	switch {
+	case IsArray(obj.FirstByte):
+		return int(obj.Length)
+	case IsMap(obj.FirstByte):
+		return int(obj.Length) * 2
+	}
+	return 0
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// countingReader counts bytes read from r to know the offset of each object.
+type countingReader struct {
+	r byteReader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// Decoder reads and decodes MessagePack objects from an input stream.
+type Decoder struct {
+	r      *countingReader
+	path   []pathElem /* containers being decoded */
+	strict bool
+	limits Limits
+	exts   *ExtRegistry
+	times  TimeFormat
+
+	discard bool /* payloads are skipped without being read into memory */
+
+	/* bytes of the top-level object being decoded. Raw of each object is a sub-slice of it. */
+	raw  []byte
+	base int64 /* offset of raw[0] in the input */
+}
+
+// DecoderOption configures Decoder.
+type DecoderOption func(*Decoder)
+
+// Strict makes the decoder report str objects which are not valid UTF-8 and 0xc1 as an error.
+// By default, such objects are decoded. MPObject.InvalidUTF8 is set for the former
+// and ErrNeverUsed is added to MPObject.Diagnostics for the latter.
+func Strict() DecoderOption {
+	return func(d *Decoder) {
+		d.strict = true
+	}
+}
+
+// NewDecoder returns a new decoder that reads from r.
+// If r does not implement io.ByteReader, the decoder wraps it with bufio.Reader
+// and may read data from r beyond the decoded objects.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := &Decoder{r: &countingReader{r: br}, exts: defaultExtRegistry}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// InputOffset returns the number of bytes consumed by the decoder.
+// It is the offset of the next object in the input.
+func (d *Decoder) InputOffset() int64 {
+	return d.r.n
+}
+
+// newError returns DecodeError which occurs at current position while decoding obj.
+func (d *Decoder) newError(kind error, obj *MPObject, need int, have int, err error) *DecodeError {
+	return &DecodeError{
+		Kind:   kind,
+		Offset: d.r.n,
+		Path:   formatPath(d.path),
+		Format: obj.FormatName,
+		Need:   need,
+		Have:   have,
+		Err:    err,
+	}
+}
+
+// next reads n bytes of obj and reports an error as DecodeError.
+func (d *Decoder) next(obj *MPObject, n int) ([]byte, error) {
+	if err := d.checkBytes(obj, d.r.n, n); err != nil {
+		return nil, err
+	}
+	start := len(d.raw)
+	var err error
+	d.raw, err = appendWithError(d.raw, d.r, n)
+	b := d.raw[start:]
+	if err == io.ErrUnexpectedEOF {
+		return b, d.newError(ErrTruncated, obj, n, len(b), err)
+	} else if err != nil {
+		return b, d.newError(nil, obj, n, len(b), err)
+	}
+	return b, nil
+}
+
+// Decode reads the next MessagePack object from its input.
+// Bytes are read on demand, so each object is returned as soon as it is complete.
+//
+// Decode returns io.EOF if the input ends between top-level objects,
+// and io.ErrUnexpectedEOF if the input ends in the middle of an object.
+// In the latter case, the error is *DecodeError which wraps io.ErrUnexpectedEOF
+// and the partially decoded object may also be returned.
+func (d *Decoder) Decode() (*MPObject, error) {
+	/* each top-level object has its own buffer since its objects share it */
+	d.raw = nil
+	d.base = d.r.n
+	obj, err := d.decode()
+	d.shareRaw(obj)
+	return obj, err
+}
+
+// shareRaw sets Raw of obj and its children to sub-slices of the buffer of the top-level object.
+// []byte and Ext values also refer to the buffer instead of copying the payload.
+func (d *Decoder) shareRaw(obj *MPObject) {
+	stack := []*MPObject{}
+	if obj != nil {
+		stack = append(stack, obj)
+	}
+	for len(stack) > 0 {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		start := o.Offset - d.base
+		end := start + o.Size
+		/* cap of Raw is limited not to overwrite the following objects by append */
+		o.Raw = d.raw[start:end:end]
+		/* values returned by ExtFormat.ValueFunc are not the payload and are kept */
+		switch v := o.Value.(type) {
+		case []byte:
+			if IsBin(o.FirstByte) {
+				o.Value = o.Raw[o.HeaderSize:]
+			}
+		case Ext:
+			if IsExt(o.FirstByte) && bytes.Equal(v.Data, o.Raw[o.HeaderSize:]) {
+				v.Data = o.Raw[o.HeaderSize:]
+				o.Value = v
+			}
 		}
-		obj.Child[i] = mpobj
-		obj.Raw = append(obj.Raw, mpobj.Raw...)
+		stack = append(stack, o.Child...)
 	}
-	return nil
 }
 
 // Decode analyzes buf and convert MPObject.
+// It consumes only the bytes of the first object from buf.
 func Decode(buf *bytes.Buffer) (*MPObject, error) {
-	return decode(buf)
+	return NewDecoder(buf).Decode()
 }
 
-func decode(buf *bytes.Buffer) (*MPObject, error) {
-	firstbyte, err := buf.ReadByte()
+// decode reads an object and its children by tokens.
+// The containers being decoded are kept in d.path instead of the call stack,
+// so the nesting depth is limited only by Limits.MaxDepth.
+func (d *Decoder) decode() (*MPObject, error) {
+	d.path = d.path[:0]
+	var root *MPObject
+	stack := []*MPObject{}
+	for {
+		kind, obj, err := d.readToken()
+		if kind != End && obj != nil {
+			if len(stack) == 0 {
+				root = obj
+			} else {
+				/* keep the broken child to show where decoding stops */
+				parent := stack[len(stack)-1]
+				parent.Child = append(parent.Child, obj)
+			}
+		}
+		if err != nil {
+			return d.abort(obj), err
+		}
+
+		switch kind {
+		case ArrayStart, MapStart:
+			capacity := numChildren(obj)
+			if capacity > maxPreallocChildren {
+				capacity = maxPreallocChildren
+			}
+			obj.Child = make([]*MPObject, 0, capacity)
+			stack = append(stack, obj)
+		case End:
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			return root, nil
+		}
+	}
+}
+
+// readToken reads the next token.
+// It returns the object of the token. For End, it is the container which ends.
+// The children of array and map are not appended to the object.
+func (d *Decoder) readToken() (TokenKind, *MPObject, error) {
+	if n := len(d.path); n > 0 && d.path[n-1].index >= numChildren(d.path[n-1].obj) {
+		obj := d.path[n-1].obj
+		d.path = d.path[:n-1]
+		d.finish(obj)
+		return End, obj, nil
+	}
+
+	obj, err := d.decodeObject()
+	if err == io.EOF && len(d.path) > 0 {
+		/* the collection is not terminated */
+		err = d.newError(ErrTruncated, d.path[len(d.path)-1].obj, 1, 0, io.ErrUnexpectedEOF)
+	}
+	kind := tokenKind(obj)
 	if err != nil {
-		/* io.EOF ?*/
+		return kind, obj, err
+	}
+
+	if kind == Scalar {
+		d.finish(obj)
+	} else {
+		d.path = append(d.path, pathElem{obj: obj})
+	}
+	return kind, obj, nil
+}
+
+// finish sets the size of obj which has been read and advances the container of obj.
+func (d *Decoder) finish(obj *MPObject) {
+	obj.Size = d.r.n - obj.Offset
+	if n := len(d.path); n > 0 {
+		parent := &d.path[n-1]
+		if IsMap(parent.obj.FirstByte) && parent.index%2 == 0 {
+			parent.key = obj
+		}
+		parent.index++
+	}
+}
+
+// abort finishes the broken object obj and the containers being decoded.
+// It returns the top-level object.
+func (d *Decoder) abort(obj *MPObject) *MPObject {
+	if obj != nil {
+		obj.Size = d.r.n - obj.Offset
+	}
+	if len(d.path) == 0 {
+		return obj
+	}
+	for _, v := range d.path {
+		v.obj.Size = d.r.n - v.obj.Offset
+	}
+	top := d.path[0].obj
+	d.path = d.path[:0]
+	return top
+}
+
+// decodeObject reads an object. The children of array and map are not read.
+func (d *Decoder) decodeObject() (*MPObject, error) {
+	offset := d.r.n
+	firstbyte, err := d.r.ReadByte()
+	if err != nil {
+		/* io.EOF means there is no more object. */
+		return nil, err
+	}
+	d.raw = append(d.raw, firstbyte)
+	obj := &MPObject{FirstByte: firstbyte, Offset: offset, HeaderSize: headerSize(firstbyte)}
+	if err := d.checkBytes(obj, offset, 1); err != nil {
+		/* the input continues beyond the limit */
+		obj.FormatName = formatName(firstbyte)
 		return nil, err
 	}
-	obj := &MPObject{FirstByte: firstbyte, Raw: []byte{firstbyte}}
+	obj, err = d.decodeData(obj)
+	if err == errDiscarded {
+		/* Tokenizer.Skip does not need the payload */
+		err = nil
+	}
+	return obj, err
+}
+
+func (d *Decoder) decodeData(obj *MPObject) (*MPObject, error) {
+	firstbyte := obj.FirstByte
 
 	switch {
 	case isPositiveFixInt(firstbyte):
 		obj.FormatName = "positive fixint"
-		obj.DataStr = fmt.Sprintf("%d", int8(firstbyte))
+		obj.SetValue(int64(firstbyte))
 	case isNegativeFixInt(firstbyte):
 		obj.FormatName = "negative fixint"
-		obj.DataStr = fmt.Sprintf("%d", int8(firstbyte))
+		obj.SetValue(int64(int8(firstbyte)))
 	case isFixMap(firstbyte):
 		obj.FormatName = "fixmap"
 		obj.Length = uint32(firstbyte & 0xf)
 		obj.DataStr = "(fixmap)"
-		err := obj.setCollection(buf, int(obj.Length)*2)
+		err := obj.setCollection(d)
 		if err != nil {
 			return obj, err
 		}
@@ -294,106 +640,114 @@ func decode(buf *bytes.Buffer) (*MPObject, error) {
 		obj.FormatName = "fixarray"
 		obj.Length = uint32(firstbyte & 0xf)
 		obj.DataStr = "(fixarray)"
-		err := obj.setCollection(buf, int(obj.Length))
+		err := obj.setCollection(d)
 		if err != nil {
 			return obj, err
 		}
 	case isFixStr(firstbyte):
 		obj.FormatName = "fixstr"
 		obj.Length = uint32(firstbyte & 0x1f)
-		bufs, err := nextWithError(buf, int(obj.Length))
+		bufs, err := d.payload(obj, int(obj.Length))
 		if err != nil {
 			return obj, err
 		}
-		obj.DataStr = string(bufs)
-		obj.Raw = append(obj.Raw, bufs...)
+		if err := obj.setStr(d, bufs); err != nil {
+			return obj, err
+		}
 	case isFixExt(firstbyte):
 		obj.FormatName = typeStr(firstbyte)
-		err := obj.setExtType(buf)
+		err := obj.setExtType(d)
 		if err != nil {
 			return obj, err
 		}
-		data, err := nextWithError(buf, 1<<uint(firstbyte-FixExt1Format))
+		data, err := d.payload(obj, 1<<uint(firstbyte-FixExt1Format))
 		if err != nil {
 			return obj, err
 		}
-		if !obj.setRegisteredExt(data) {
-			obj.DataStr = fmt.Sprintf("0x%x", data)
+		if !obj.setRegisteredExt(d.exts, data) {
+			obj.SetValue(Ext{Type: obj.ExtType, Data: data})
 		}
-		obj.Raw = append(obj.Raw, data...)
+		d.formatTime(obj)
 	case isExt(firstbyte):
 		obj.FormatName = typeStr(firstbyte)
 		/* length */
 		switch firstbyte {
 		case Ext8Format:
-			err := obj.setLengthFromBytes(1, buf)
+			err := obj.setLengthFromBytes(1, d)
 			if err != nil {
 				return obj, err
 			}
 		case Ext16Format:
-			err := obj.setLengthFromBytes(2, buf)
+			err := obj.setLengthFromBytes(2, d)
 			if err != nil {
 				return obj, err
 			}
 		case Ext32Format:
-			err := obj.setLengthFromBytes(4, buf)
+			err := obj.setLengthFromBytes(4, d)
 			if err != nil {
 				return obj, err
 			}
 		}
 
 		/* type */
-		err := obj.setExtType(buf)
+		err := obj.setExtType(d)
 		if err != nil {
 			return obj, err
 		}
 
-		data, err := nextWithError(buf, int(obj.Length))
+		data, err := d.payload(obj, int(obj.Length))
 		if err != nil {
 			return obj, err
 		}
 
-		if !obj.setRegisteredExt(data) {
-			obj.DataStr = fmt.Sprintf("0x%x", data)
+		if !obj.setRegisteredExt(d.exts, data) {
+			obj.SetValue(Ext{Type: obj.ExtType, Data: data})
 		}
-		obj.Raw = append(obj.Raw, data...)
+		d.formatTime(obj)
 
 	default:
 		obj.FormatName = typeStr(firstbyte)
 		switch firstbyte {
 		case NilFormat:
-			obj.DataStr = "nil"
+			obj.SetValue(nil)
 		case NeverUsedFormat:
 			obj.DataStr = "(never used)"
+			e := d.newError(ErrNeverUsed, obj, 0, 0, nil)
+			e.Offset = obj.Offset
+			if d.strict {
+				return obj, e
+			}
+			/* the byte has no payload, so the following objects can be decoded */
+			obj.Diagnostics = append(obj.Diagnostics, e)
 		case TrueFormat:
-			obj.DataStr = "true"
+			obj.SetValue(true)
 		case FalseFormat:
-			obj.DataStr = "false"
+			obj.SetValue(false)
 			/* Uint family*/
 		case Uint8Format:
-			err := obj.setNum(1, buf, func(b []byte) string {
-				return fmt.Sprintf("%d", uint8(b[0]))
+			err := obj.setNum(1, d, func(b []byte) interface{} {
+				return uint64(b[0])
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Uint16Format:
-			obj.setNum(2, buf, func(b []byte) string {
-				return fmt.Sprintf("%d", (binary.BigEndian.Uint16(b)))
+			err := obj.setNum(2, d, func(b []byte) interface{} {
+				return uint64(binary.BigEndian.Uint16(b))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Uint32Format:
-			obj.setNum(4, buf, func(b []byte) string {
-				return fmt.Sprintf("%d", (binary.BigEndian.Uint32(b)))
+			err := obj.setNum(4, d, func(b []byte) interface{} {
+				return uint64(binary.BigEndian.Uint32(b))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Uint64Format:
-			obj.setNum(8, buf, func(b []byte) string {
-				return fmt.Sprintf("%d", (binary.BigEndian.Uint64(b)))
+			err := obj.setNum(8, d, func(b []byte) interface{} {
+				return binary.BigEndian.Uint64(b)
 			})
 			if err != nil {
 				return obj, err
@@ -401,184 +755,160 @@ func decode(buf *bytes.Buffer) (*MPObject, error) {
 
 			/* Int family */
 		case Int8Format:
-			obj.setNum(1, buf, func(b []byte) string {
-				var v int8
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%d", v)
+			err := obj.setNum(1, d, func(b []byte) interface{} {
+				return int64(int8(b[0]))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Int16Format:
-			obj.setNum(2, buf, func(b []byte) string {
-				var v int16
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%d", v)
+			err := obj.setNum(2, d, func(b []byte) interface{} {
+				return int64(int16(binary.BigEndian.Uint16(b)))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Int32Format:
-			obj.setNum(4, buf, func(b []byte) string {
-				var v int32
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%d", v)
+			err := obj.setNum(4, d, func(b []byte) interface{} {
+				return int64(int32(binary.BigEndian.Uint32(b)))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Int64Format:
-			obj.setNum(8, buf, func(b []byte) string {
-				var v int64
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%d", v)
+			err := obj.setNum(8, d, func(b []byte) interface{} {
+				return int64(binary.BigEndian.Uint64(b))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Float32Format:
-			obj.setNum(4, buf, func(b []byte) string {
-				var v float32
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%f", v)
+			err := obj.setNum(4, d, func(b []byte) interface{} {
+				return math.Float32frombits(binary.BigEndian.Uint32(b))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Float64Format:
-			obj.setNum(8, buf, func(b []byte) string {
-				var v float64
-				if binary.Read(bytes.NewReader(b), binary.BigEndian, &v) != nil {
-					return ""
-				}
-				return fmt.Sprintf("%f", v)
+			err := obj.setNum(8, d, func(b []byte) interface{} {
+				return math.Float64frombits(binary.BigEndian.Uint64(b))
 			})
 			if err != nil {
 				return obj, err
 			}
 		case Str8Format:
-			err := obj.setLengthFromBytes(1, buf)
+			err := obj.setLengthFromBytes(1, d)
 			if err != nil {
 				return obj, err
 			}
 
-			str, err := nextWithError(buf, int(obj.Length))
+			str, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, str...)
-			obj.DataStr = string(str)
+			if err := obj.setStr(d, str); err != nil {
+				return obj, err
+			}
 
 		case Str16Format:
-			err := obj.setLengthFromBytes(2, buf)
+			err := obj.setLengthFromBytes(2, d)
 			if err != nil {
 				return obj, err
 			}
-			str, err := nextWithError(buf, int(obj.Length))
+			str, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, str...)
-			obj.DataStr = string(str)
+			if err := obj.setStr(d, str); err != nil {
+				return obj, err
+			}
 
 		case Str32Format:
-			err := obj.setLengthFromBytes(4, buf)
+			err := obj.setLengthFromBytes(4, d)
 			if err != nil {
 				return obj, err
 			}
-			str, err := nextWithError(buf, int(obj.Length))
+			str, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, str...)
-			obj.DataStr = string(str)
+			if err := obj.setStr(d, str); err != nil {
+				return obj, err
+			}
 
 		case Bin8Format:
-			err := obj.setLengthFromBytes(1, buf)
+			err := obj.setLengthFromBytes(1, d)
 			if err != nil {
 				return obj, err
 			}
-			bins, err := nextWithError(buf, int(obj.Length))
+			bins, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, bins...)
-			obj.DataStr = fmt.Sprintf("0x%x", bins)
+			obj.SetValue(bins)
 		case Bin16Format:
-			err := obj.setLengthFromBytes(2, buf)
+			err := obj.setLengthFromBytes(2, d)
 			if err != nil {
 				return obj, err
 			}
-			bins, err := nextWithError(buf, int(obj.Length))
+			bins, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, bins...)
-			obj.DataStr = fmt.Sprintf("0x%x", bins)
+			obj.SetValue(bins)
 
 		case Bin32Format:
-			err := obj.setLengthFromBytes(4, buf)
+			err := obj.setLengthFromBytes(4, d)
 			if err != nil {
 				return obj, err
 			}
-			bins, err := nextWithError(buf, int(obj.Length))
+			bins, err := d.payload(obj, int(obj.Length))
 			if err != nil {
 				return obj, err
 			}
-			obj.Raw = append(obj.Raw, bins...)
-			obj.DataStr = fmt.Sprintf("0x%x", bins)
+			obj.SetValue(bins)
 		case Array16Format:
-			err := obj.setLengthFromBytes(2, buf)
+			err := obj.setLengthFromBytes(2, d)
 			if err != nil {
 				return obj, err
 			}
 			obj.DataStr = "(array 16)"
-			err = obj.setCollection(buf, int(obj.Length))
+			err = obj.setCollection(d)
 			if err != nil {
-				return nil, err
+				return obj, err
 			}
 
 		case Array32Format:
-			err := obj.setLengthFromBytes(4, buf)
+			err := obj.setLengthFromBytes(4, d)
 			if err != nil {
 				return obj, err
 			}
 			obj.DataStr = "(array 32)"
-			err = obj.setCollection(buf, int(obj.Length))
+			err = obj.setCollection(d)
 			if err != nil {
-				return nil, err
+				return obj, err
 			}
 
 		case Map16Format:
-			err := obj.setLengthFromBytes(2, buf)
+			err := obj.setLengthFromBytes(2, d)
 			if err != nil {
 				return obj, err
 			}
 			obj.DataStr = "(map 16)"
-			err = obj.setCollection(buf, int(obj.Length)*2)
+			err = obj.setCollection(d)
 			if err != nil {
-				return nil, err
+				return obj, err
 			}
 
 		case Map32Format:
-			err := obj.setLengthFromBytes(4, buf)
+			err := obj.setLengthFromBytes(4, d)
 			if err != nil {
 				return obj, err
 			}
 			obj.DataStr = "(map 32)"
-			err = obj.setCollection(buf, int(obj.Length)*2)
+			err = obj.setCollection(d)
 			if err != nil {
-				return nil, err
+				return obj, err
 			}
 		}
 	}
@@ -18,19 +18,24 @@ package msgpack
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"runtime/debug"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
-func TestDecode(t *testing.T) {
-	type testcase struct {
-		casename string
-		bytes    []byte
-		obj      *MPObject
-	}
+type decodeTestCase struct {
+	casename string
+	bytes    []byte
+	obj      *MPObject
+}
+
+// decodeTestCases returns a case of each format.
+func decodeTestCases() []decodeTestCase {
+	type testcase = decodeTestCase
 
 	cases := []testcase{
 		{"p fixint", []byte{0x01}, &MPObject{DataStr: "1", FormatName: "positive fixint"}},
@@ -39,8 +44,8 @@ func TestDecode(t *testing.T) {
 		{"never used", []byte{0xc1}, &MPObject{DataStr: "(never used)", FormatName: "(never used)"}},
 		{"true", []byte{0xc3}, &MPObject{DataStr: "true", FormatName: "true"}},
 		{"false", []byte{0xc2}, &MPObject{DataStr: "false", FormatName: "false"}},
-		{"float32", []byte{0xca, 0x80, 0x00, 0x00, 0x00}, &MPObject{DataStr: "-0.000000", FormatName: "float 32"}},
-		{"float64", []byte{0xcb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, &MPObject{DataStr: "-0.000000", FormatName: "float 64"}},
+		{"float32", []byte{0xca, 0x80, 0x00, 0x00, 0x00}, &MPObject{DataStr: "-0", FormatName: "float 32"}},
+		{"float64", []byte{0xcb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, &MPObject{DataStr: "-0", FormatName: "float 64"}},
 		{"uint8", []byte{0xcc, 0xff}, &MPObject{DataStr: "255", FormatName: "uint 8"}},
 		{"uint16", []byte{0xcd, 0xff, 0x00}, &MPObject{DataStr: "65280", FormatName: "uint 16"}},
 		{"uint32", []byte{0xce, 0xff, 0x00, 0xff, 0x00}, &MPObject{DataStr: "4278255360", FormatName: "uint 32"}},
@@ -135,6 +140,12 @@ func TestDecode(t *testing.T) {
 	}
 	cases = append(cases, strcase)
 
+	return cases
+}
+
+func TestDecode(t *testing.T) {
+	cases := decodeTestCases()
+
 	for _, v := range cases {
 		v.obj.Raw = v.bytes
 		v.obj.FirstByte = v.bytes[0]
@@ -200,6 +211,38 @@ func BenchmarkDecodeNestedMap(b *testing.B) {
 	}
 }
 
+func BenchmarkDecodeDeepNestedMap(b *testing.B) {
+	/* {"k":{"k":...{"k":"<64 bytes>"}...}} which has 100 levels */
+	benchData := []byte{}
+	for i := 0; i < 100; i++ {
+		benchData = append(benchData, 0x81, 0xa1, 0x6b)
+	}
+	benchData = append(benchData, 0xd9, 0x40)
+	benchData = append(benchData, bytes.Repeat([]byte{0x41}, 0x40)...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := Decode(bytes.NewBuffer(benchData))
+		if err != nil && err != io.EOF {
+			b.Errorf("Decode error %s", err)
+		}
+	}
+}
+
+func BenchmarkDecodeLargeArray(b *testing.B) {
+	/* ["AAAAAAAA", ...] which has 10000 elements */
+	benchData := []byte{0xdc, 0x27, 0x10}
+	for i := 0; i < 10000; i++ {
+		benchData = append(benchData, 0xa8, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := Decode(bytes.NewBuffer(benchData))
+		if err != nil && err != io.EOF {
+			b.Errorf("Decode error %s", err)
+		}
+	}
+}
+
 func BenchmarkDecode(b *testing.B) {
 	/* {"val":12.3, "str":"hoge"} in JSON */
 	benchData := []byte{0x82, 0xa3, 0x76, 0x61, 0x6c, 0xcb, 0x40, 0x28, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a, 0xa3, 0x73, 0x74, 0x72, 0xa4, 0x68, 0x6f, 0x67, 0x65}
@@ -392,3 +435,204 @@ func TestNextWithError(t *testing.T) {
 		t.Errorf("NextWithError is not successed")
 	}
 }
+
+func TestDecoderStream(t *testing.T) {
+	/* 1, "AB", [0,1] */
+	b := []byte{0x01, 0xa2, 0x41, 0x42, 0x92, 0x00, 0x01}
+	expected := []string{"1", "AB", "(fixarray)"}
+
+	readers := map[string]io.Reader{
+		"bytes.Buffer":  bytes.NewBuffer(b),
+		"OneByteReader": iotest.OneByteReader(bytes.NewReader(b)),
+	}
+
+	for name, r := range readers {
+		dec := NewDecoder(r)
+		for i, v := range expected {
+			ret, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%s: %d: Decode error %s", name, i, err)
+			}
+			if ret.DataStr != v {
+				t.Errorf("%s: %d: DataStr mismatch: %s, expect %s", name, i, ret.DataStr, v)
+			}
+		}
+		_, err := dec.Decode()
+		if err != io.EOF {
+			t.Errorf("%s: io.EOF is not returned. err=%v", name, err)
+		}
+	}
+}
+
+func TestDecoderUnexpectedEOF(t *testing.T) {
+	cases := [][]byte{
+		{0x92, 0x01},
+		{0xa2, 0x41},
+		{0xd6, 0x01},
+		{0x82, 0xa1, 0x41},
+		{0xcd, 0x01},
+		{0xd3, 0x01, 0x02},
+		{0xcb, 0x01},
+	}
+
+	for _, v := range cases {
+		dec := NewDecoder(iotest.OneByteReader(bytes.NewReader(v)))
+		_, err := dec.Decode()
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("0x%x: io.ErrUnexpectedEOF is not returned. err=%v", v, err)
+		}
+	}
+}
+
+func TestDecodeConsumesOneObject(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{0x92, 0x00, 0x01, 0xc3})
+	_, err := Decode(buf)
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	if buf.Len() != 1 {
+		t.Errorf("remaining size mismatch: %d, expect 1", buf.Len())
+	}
+}
+
+func TestDecodeDeepNesting(t *testing.T) {
+	/* [[[...[1]...]]] which has 100000 levels */
+	depth := 100000
+	b := append(bytes.Repeat([]byte{0x91}, depth), 0x01)
+	obj, err := Decode(bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	for i := 0; i < depth; i++ {
+		if obj.Size != int64(len(b)-i) || obj.Offset != int64(i) || len(obj.Child) != 1 {
+			t.Fatalf("level %d: mismatch. offset=%d size=%d children=%d", i, obj.Offset, obj.Size, len(obj.Child))
+		}
+		obj = obj.Child[0]
+	}
+	if v, ok := obj.Int(); !ok || v != 1 {
+		t.Errorf("leaf mismatch. given: %v", obj)
+	}
+
+	/* truncated */
+	obj, err = Decode(bytes.NewBuffer(b[:depth]))
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("ErrTruncated is not returned. err=%v", err)
+	}
+	if obj == nil || obj.Size != int64(depth) {
+		t.Errorf("partial object mismatch. given: %v", obj)
+	}
+
+	/* limited */
+	_, err = NewDecoder(bytes.NewReader(b), WithLimits(Limits{MaxDepth: 1000})).Decode()
+	if !errors.Is(err, ErrDepthLimit) {
+		t.Errorf("ErrDepthLimit is not returned. err=%v", err)
+	}
+}
+
+func TestRawShared(t *testing.T) {
+	/* {"A":[1, 0xc4 0x02 0xde 0xad]} */
+	b := []byte{0x81, 0xa1, 0x41, 0x92, 0x01, 0xc4, 0x02, 0xde, 0xad}
+	obj, err := Decode(bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if !bytes.Equal(obj.Raw, b) {
+		t.Errorf("Raw mismatch. given: %x expected: %x", obj.Raw, b)
+	}
+
+	arr := obj.Child[1]
+	bin := arr.Child[1]
+	if &arr.Raw[0] != &obj.Raw[3] || &bin.Raw[0] != &obj.Raw[5] {
+		t.Errorf("Raw is not a sub-slice of the parent")
+	}
+	if v, ok := bin.Bytes(); !ok || &v[0] != &obj.Raw[7] {
+		t.Errorf("bin value is not a sub-slice of Raw")
+	}
+
+	/* append to Raw of a child must not overwrite the following objects */
+	_ = append(arr.Child[0].Raw, 0xff)
+	if !bytes.Equal(obj.Raw, b) {
+		t.Errorf("Raw is overwritten. given: %x expected: %x", obj.Raw, b)
+	}
+}
+
+func TestRawStream(t *testing.T) {
+	/* objects decoded before must not be overwritten by the following objects */
+	dec := NewDecoder(bytes.NewReader([]byte{0x92, 0x01, 0x02, 0x92, 0x03, 0x04}))
+	first, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if !bytes.Equal(first.Raw, []byte{0x92, 0x01, 0x02}) || !bytes.Equal(first.Child[1].Raw, []byte{0x02}) {
+		t.Errorf("Raw mismatch. given: %x", first.Raw)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	/* {"A":[1, 0x10000], "B":"AB"} in JSON, followed by nil */
+	b := []byte{0x82, 0xa1, 0x41, 0x92, 0x01, 0xce, 0x00, 0x01, 0x00, 0x00, 0xa1, 0x42, 0xd9, 0x02, 0x41, 0x42, 0xc0}
+
+	type testcase struct {
+		casename   string
+		obj        *MPObject
+		offset     int64
+		headerSize int
+		size       int64
+	}
+
+	dec := NewDecoder(bytes.NewBuffer(b))
+	ret, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	next, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+
+	cases := []testcase{
+		{"fixmap", ret, 0, 1, 16},
+		{"key A", ret.Child[0], 1, 1, 2},
+		{"fixarray", ret.Child[1], 3, 1, 7},
+		{"fixint", ret.Child[1].Child[0], 4, 1, 1},
+		{"uint32", ret.Child[1].Child[1], 5, 1, 5},
+		{"key B", ret.Child[2], 10, 1, 2},
+		{"str8", ret.Child[3], 12, 2, 4},
+		{"nil", next, 16, 1, 1},
+	}
+
+	for _, v := range cases {
+		if v.obj.Offset != v.offset {
+			t.Errorf("%s: Offset mismatch: %d, expect %d", v.casename, v.obj.Offset, v.offset)
+		}
+		if v.obj.HeaderSize != v.headerSize {
+			t.Errorf("%s: HeaderSize mismatch: %d, expect %d", v.casename, v.obj.HeaderSize, v.headerSize)
+		}
+		if v.obj.Size != v.size {
+			t.Errorf("%s: Size mismatch: %d, expect %d", v.casename, v.obj.Size, v.size)
+		}
+	}
+
+	if dec.InputOffset() != int64(len(b)) {
+		t.Errorf("InputOffset mismatch: %d, expect %d", dec.InputOffset(), len(b))
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	cases := map[byte]int{
+		0x01: 1, 0x82: 1, 0x92: 1, 0xa1: 1, 0xc0: 1, 0xcb: 1,
+		Bin8Format: 2, Str8Format: 2, FixExt1Format: 2, FixExt16Format: 2,
+		Bin16Format: 3, Str16Format: 3, Array16Format: 3, Map16Format: 3, Ext8Format: 3,
+		Ext16Format: 4,
+		Bin32Format: 5, Str32Format: 5, Array32Format: 5, Map32Format: 5,
+		Ext32Format: 6,
+	}
+	for b, v := range cases {
+		if headerSize(b) != v {
+			t.Errorf("0x%02x: headerSize mismatch: %d, expect %d", b, headerSize(b), v)
+		}
+	}
+}
@@ -0,0 +1,718 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* JSONPath-like query to select objects. */
+
+// Match is an object selected by a query.
+type Match struct {
+	Path Path
+	Obj  *MPObject
+}
+
+type stepKind int
+
+const (
+	stepKey      stepKind = iota /* .name or ["name"]: string key of map */
+	stepIndex                    /* [0] or [-1]: index of array, or non-string key of map like [1] and [true] */
+	stepWildcard                 /* .* or [*]: all elements */
+	stepSlice                    /* [start:end:step]: elements of array */
+	stepFilter                   /* [?(predicate)]: elements which satisfy predicate */
+)
+
+// step represents a selector of the query.
+type step struct {
+	kind      stepKind
+	recursive bool   /* ..: the selector is applied to the object and all its descendants */
+	name      string /* for stepKey and stepIndex */
+	index     int    /* for stepIndex. valid if isIndex */
+	isIndex   bool
+	slice     [3]int /* start, end and step */
+	hasSlice  [3]bool
+	filter    *predicate
+}
+
+// Selector is a compiled query.
+//
+// The query starts with $ which is the top-level object and the following selectors are supported.
+//
+//	.name, ["name"], ['name']  value of map whose key is the string
+//	[0], [-1]                  element of array. negative index counts from the end
+//	[1], [true], [nil]         value of map whose key is not string and shown as the text
+//	.*, [*]                    all elements of array and all values of map
+//	[start:end:step]           elements of array like Python slice. e.g. [1:], [:-1] and [::2]
+//	[?(predicate)]             elements which satisfy predicate
+//	..selector                 the selector applied to the object and all its descendants. e.g. ..name and ..[0]
+//
+// Predicate compares operands with ==, !=, <, <=, > and >=, and combines them with &&, || and !.
+// An operand is a literal (string, number, true, false and null) or a relative path from the element
+// like @, @.level and @[0]. An operand path without comparison checks whether the object exists.
+// A number compared with float is rounded to the precision of the float, so @.v == 0.1 matches float 32 of 0.1.
+// e.g. [?(@.level == "error" && @.code >= 500)]
+type Selector struct {
+	expr  string
+	steps []step
+}
+
+// String returns the query.
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// CompileQuery parses a query.
+func CompileQuery(expr string) (*Selector, error) {
+	p := &queryParser{expr: expr}
+	if !p.consume("$") {
+		return nil, p.errorf("query must start with $")
+	}
+	steps := []step{}
+	for p.pos < len(p.expr) {
+		st, err := p.parseStep(false)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, st)
+	}
+	return &Selector{expr: expr, steps: steps}, nil
+}
+
+// Query selects the objects matched by expr from obj. See Selector for the syntax.
+func Query(obj *MPObject, expr string) ([]Match, error) {
+	s, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Select(obj), nil
+}
+
+// Select returns the objects matched by s.
+// The objects selected by .. are in document order. The other selectors keep the order
+// of the elements they select, e.g. [::-1] returns the elements in reverse order.
+func (s *Selector) Select(obj *MPObject) []Match {
+	if obj == nil {
+		return nil
+	}
+	current := []Match{{Path: Path{}, Obj: obj}}
+	for i := range s.steps {
+		st := &s.steps[i]
+		next := []Match{}
+		for _, m := range current {
+			if !st.recursive {
+				st.apply(m, &next)
+				continue
+			}
+			base := m.Path
+			start := len(next)
+			Walk(m.Obj, func(path Path, obj *MPObject) error {
+				p := append(base[:len(base):len(base)], path...)
+				st.apply(Match{Path: p, Obj: obj}, &next)
+				return nil
+			})
+			/* Walk visits a parent before its children, but the selected children of the parent
+			   may follow the descendants of its earlier children in the input. */
+			found := next[start:]
+			sort.SliceStable(found, func(i, j int) bool {
+				return pathLess(found[i].Path, found[j].Path)
+			})
+		}
+		current = next
+	}
+	return current
+}
+
+// pathLess reports whether the object at a precedes the object at b in the input.
+func pathLess(a Path, b Path) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Index != b[i].Index {
+			return a[i].Index < b[i].Index
+		}
+	}
+	/* a parent precedes its descendants */
+	return len(a) < len(b)
+}
+
+// apply appends the elements of m.Obj selected by st to out.
+func (st *step) apply(m Match, out *[]Match) {
+	obj := m.Obj
+	add := func(elem PathElem, child *MPObject) {
+		/* the full slice expression makes append copy the path */
+		p := append(m.Path[:len(m.Path):len(m.Path)], elem)
+		*out = append(*out, Match{Path: p, Obj: child})
+	}
+
+	switch {
+	case IsArray(obj.FirstByte):
+		switch st.kind {
+		case stepIndex:
+			i := st.index
+			if i < 0 {
+				i += len(obj.Child)
+			}
+			if st.isIndex && i >= 0 && i < len(obj.Child) {
+				add(PathElem{Index: i}, obj.Child[i])
+			}
+		case stepSlice:
+			for _, i := range st.sliceIndexes(len(obj.Child)) {
+				add(PathElem{Index: i}, obj.Child[i])
+			}
+		case stepWildcard, stepFilter:
+			for i, v := range obj.Child {
+				if st.kind == stepWildcard || st.filter.eval(v) {
+					add(PathElem{Index: i}, v)
+				}
+			}
+		}
+	case IsMap(obj.FirstByte):
+		for i := 0; i+1 < len(obj.Child); i += 2 {
+			key, value := obj.Child[i], obj.Child[i+1]
+			matched := false
+			switch st.kind {
+			case stepKey:
+				matched = IsString(key.FirstByte) && key.DataStr == st.name
+			case stepIndex:
+				matched = !IsString(key.FirstByte) && key.DataStr == st.name
+			case stepWildcard:
+				matched = true
+			case stepFilter:
+				matched = st.filter.eval(value)
+			}
+			if matched {
+				add(PathElem{Index: i / 2, Key: key}, value)
+			}
+		}
+	}
+}
+
+// sliceIndexes returns the indexes of array of length n selected by slice.
+func (st *step) sliceIndexes(n int) []int {
+	normalize := func(i int, min int, max int) int {
+		if i < 0 {
+			i += n
+		}
+		if i < min {
+			return min
+		} else if i > max {
+			return max
+		}
+		return i
+	}
+
+	ret := []int{}
+	inc := 1
+	if st.hasSlice[2] {
+		inc = st.slice[2]
+	}
+	if inc > 0 {
+		start, end := 0, n
+		if st.hasSlice[0] {
+			start = normalize(st.slice[0], 0, n)
+		}
+		if st.hasSlice[1] {
+			end = normalize(st.slice[1], 0, n)
+		}
+		for i := start; i < end; i += inc {
+			ret = append(ret, i)
+		}
+		return ret
+	}
+	start, end := n-1, -1
+	if st.hasSlice[0] {
+		start = normalize(st.slice[0], -1, n-1)
+	}
+	if st.hasSlice[1] {
+		end = normalize(st.slice[1], -1, n-1)
+	}
+	for i := start; i > end; i += inc {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+// lookup returns the object at the relative path steps from obj.
+func lookup(obj *MPObject, steps []step) (*MPObject, bool) {
+	for i := range steps {
+		found := []Match{}
+		steps[i].apply(Match{Obj: obj}, &found)
+		if len(found) == 0 {
+			return nil, false
+		}
+		obj = found[0].Obj
+	}
+	return obj, true
+}
+
+// predicate is a condition of filter selector.
+type predicate struct {
+	op          string /* "||", "&&", "!", "exists" or comparison operator */
+	left, right *predicate
+	lhs, rhs    operand
+}
+
+// operand is a literal or a relative path from the element.
+type operand struct {
+	isPath bool
+	path   []step
+	value  interface{} /* nil, bool, literal or string */
+}
+
+// literal is a number in a query.
+type literal struct {
+	text  string
+	value *big.Float
+}
+
+// floatValue is a value of float 32 or float 64.
+type floatValue struct {
+	value float64
+	bits  int /* 32 or 64 */
+}
+
+// eval reports whether obj satisfies p.
+func (p *predicate) eval(obj *MPObject) bool {
+	switch p.op {
+	case "||":
+		return p.left.eval(obj) || p.right.eval(obj)
+	case "&&":
+		return p.left.eval(obj) && p.right.eval(obj)
+	case "!":
+		return !p.left.eval(obj)
+	case "exists":
+		_, ok := lookup(obj, p.lhs.path)
+		return ok
+	}
+	lhs, ok := p.lhs.resolve(obj)
+	if !ok {
+		return false
+	}
+	rhs, ok := p.rhs.resolve(obj)
+	if !ok {
+		return false
+	}
+	return compare(lhs, rhs, p.op)
+}
+
+// resolve returns the comparable value of the operand.
+// ok is false if the object does not exist or is not comparable, e.g. array.
+func (o operand) resolve(obj *MPObject) (interface{}, bool) {
+	if !o.isPath {
+		return o.value, true
+	}
+	obj, ok := lookup(obj, o.path)
+	if !ok {
+		return nil, false
+	}
+	switch v := obj.Value.(type) {
+	case nil:
+		return nil, obj.IsNil()
+	case bool, string:
+		return v, true
+	case int64:
+		return new(big.Float).SetInt64(v), true
+	case uint64:
+		return new(big.Float).SetUint64(v), true
+	case float32:
+		if !math.IsNaN(float64(v)) {
+			return floatValue{float64(v), 32}, true
+		}
+	case float64:
+		if !math.IsNaN(v) {
+			return floatValue{v, 64}, true
+		}
+	}
+	return nil, false
+}
+
+// number converts a numeric value into *big.Float to be compared with other.
+// A literal compared with float is rounded to the precision of the float,
+// so 0.1 is equal to float 32 and float 64 of 0.1 in the data.
+func number(v interface{}, other interface{}) interface{} {
+	switch n := v.(type) {
+	case literal:
+		if f, ok := other.(floatValue); ok {
+			if r, err := strconv.ParseFloat(n.text, f.bits); err == nil || errors.Is(err, strconv.ErrRange) {
+				return new(big.Float).SetFloat64(r)
+			}
+		}
+		return n.value
+	case floatValue:
+		return new(big.Float).SetFloat64(n.value)
+	}
+	return v
+}
+
+// compare compares the values by op. The values of different types are only not equal.
+func compare(lhs interface{}, rhs interface{}, op string) bool {
+	lhs, rhs = number(lhs, rhs), number(rhs, lhs)
+	cmp := 0
+	switch l := lhs.(type) {
+	case nil:
+		if rhs != nil {
+			return op == "!="
+		}
+	case bool:
+		r, ok := rhs.(bool)
+		if !ok {
+			return op == "!="
+		}
+		if l != r {
+			cmp = 1
+		}
+		if op != "==" && op != "!=" {
+			return false
+		}
+	case string:
+		r, ok := rhs.(string)
+		if !ok {
+			return op == "!="
+		}
+		cmp = strings.Compare(l, r)
+	case *big.Float:
+		r, ok := rhs.(*big.Float)
+		if !ok {
+			return op == "!="
+		}
+		cmp = l.Cmp(r)
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// queryParser parses a query.
+type queryParser struct {
+	expr string
+	pos  int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query %q: %s at %d", p.expr, fmt.Sprintf(format, args...), p.pos)
+}
+
+// consume skips s if the rest of the query starts with s.
+func (p *queryParser) consume(s string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) skipSpaces() {
+	for p.pos < len(p.expr) && p.expr[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseStep parses a selector. In predicate, only keys and indexes are allowed.
+func (p *queryParser) parseStep(inPredicate bool) (step, error) {
+	st := step{}
+	start := p.pos
+	switch {
+	case !inPredicate && p.consume(".."):
+		st.recursive = true
+		if p.pos < len(p.expr) && p.expr[p.pos] == '[' {
+			p.pos++
+			return p.parseBracket(st)
+		}
+	case p.consume("."):
+	case p.consume("["):
+		st, err := p.parseBracket(st)
+		if err == nil && inPredicate && st.kind != stepKey && st.kind != stepIndex {
+			p.pos = start
+			return st, p.errorf("only keys and indexes are allowed in predicate")
+		}
+		return st, err
+	default:
+		return st, p.errorf("unexpected %q", p.expr[p.pos])
+	}
+
+	if !inPredicate && p.consume("*") {
+		st.kind = stepWildcard
+		return st, nil
+	}
+	end := p.pos
+	for end < len(p.expr) && !strings.ContainsRune(".[]()=!<>&|, ", rune(p.expr[end])) {
+		end++
+	}
+	if end == p.pos {
+		return st, p.errorf("name is missing")
+	}
+	st.kind = stepKey
+	st.name = p.expr[p.pos:end]
+	p.pos = end
+	return st, nil
+}
+
+// parseBracket parses a selector after '['.
+func (p *queryParser) parseBracket(st step) (step, error) {
+	p.skipSpaces()
+	switch {
+	case p.consume("*"):
+		st.kind = stepWildcard
+	case p.consume("?("):
+		pred, err := p.parseOr()
+		if err != nil {
+			return st, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return st, p.errorf("')' is missing")
+		}
+		st.kind = stepFilter
+		st.filter = pred
+	case p.pos < len(p.expr) && (p.expr[p.pos] == '"' || p.expr[p.pos] == '\''):
+		s, err := p.parseString()
+		if err != nil {
+			return st, err
+		}
+		st.kind = stepKey
+		st.name = s
+	default:
+		end := strings.IndexByte(p.expr[p.pos:], ']')
+		if end < 0 {
+			return st, p.errorf("']' is missing")
+		}
+		text := strings.TrimSpace(p.expr[p.pos : p.pos+end])
+		if err := p.parseIndex(&st, text); err != nil {
+			return st, err
+		}
+		p.pos += end
+	}
+	p.skipSpaces()
+	if !p.consume("]") {
+		return st, p.errorf("']' is missing")
+	}
+	return st, nil
+}
+
+// parseIndex parses index, slice or non-string key.
+func (p *queryParser) parseIndex(st *step, text string) error {
+	if text == "" {
+		return p.errorf("index is missing")
+	}
+	if !strings.Contains(text, ":") {
+		st.kind = stepIndex
+		st.name = text
+		st.index, st.isIndex = parseInt(text)
+		return nil
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return p.errorf("invalid slice %q", text)
+	}
+	st.kind = stepSlice
+	for i, v := range parts {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		n, ok := parseInt(v)
+		if !ok {
+			return p.errorf("invalid slice %q", text)
+		}
+		st.slice[i] = n
+		st.hasSlice[i] = true
+	}
+	if st.hasSlice[2] && st.slice[2] == 0 {
+		return p.errorf("slice step must not be 0")
+	}
+	return nil
+}
+
+func parseInt(s string) (int, bool) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	return int(n), err == nil
+}
+
+// parseString parses a string quoted by " or '.
+// " string is unquoted as Go string literal which Path.String uses.
+func (p *queryParser) parseString() (string, error) {
+	quote := p.expr[p.pos]
+	end := p.pos + 1
+	for ; end < len(p.expr) && p.expr[end] != quote; end++ {
+		if p.expr[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(p.expr) {
+		return "", p.errorf("string is not terminated")
+	}
+	text := p.expr[p.pos : end+1]
+	if quote == '\'' {
+		text = `"` + strings.NewReplacer(`\'`, `'`, `"`, `\"`).Replace(text[1:len(text)-1]) + `"`
+	}
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		return "", p.errorf("invalid string %s", p.expr[p.pos:end+1])
+	}
+	p.pos = end + 1
+	return s, nil
+}
+
+func (p *queryParser) parseOr() (*predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if !p.consume("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: "||", left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseAnd() (*predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if !p.consume("&&") {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseUnary() (*predicate, error) {
+	p.skipSpaces()
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "!") && !strings.HasPrefix(rest, "!="):
+		p.pos++
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &predicate{op: "!", left: pred}, nil
+	case p.consume("("):
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return nil, p.errorf("')' is missing")
+		}
+		return pred, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (*predicate, error) {
+	lhs, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+		rhs, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &predicate{op: op, lhs: lhs, rhs: rhs}, nil
+	}
+	if !lhs.isPath {
+		return nil, p.errorf("comparison operator is missing")
+	}
+	return &predicate{op: "exists", lhs: lhs}, nil
+}
+
+func (p *queryParser) parseOperand() (operand, error) {
+	p.skipSpaces()
+	o := operand{}
+	if p.pos >= len(p.expr) {
+		return o, p.errorf("operand is missing")
+	}
+	switch c := p.expr[p.pos]; {
+	case c == '@':
+		p.pos++
+		o.isPath = true
+		for p.pos < len(p.expr) && (p.expr[p.pos] == '.' || p.expr[p.pos] == '[') {
+			st, err := p.parseStep(true)
+			if err != nil {
+				return o, err
+			}
+			o.path = append(o.path, st)
+		}
+		return o, nil
+	case c == '"' || c == '\'':
+		s, err := p.parseString()
+		o.value = s
+		return o, err
+	}
+
+	end := p.pos
+	for end < len(p.expr) && !strings.ContainsRune("()=!<>&| ", rune(p.expr[end])) {
+		end++
+	}
+	text := p.expr[p.pos:end]
+	switch text {
+	case "null", "nil":
+		o.value = nil
+	case "true":
+		o.value = true
+	case "false":
+		o.value = false
+	default:
+		f, _, err := big.ParseFloat(text, 10, 128, big.ToNearestEven)
+		if err != nil {
+			return o, p.errorf("invalid literal %q", text)
+		}
+		o.value = literal{text, f}
+	}
+	p.pos = end
+	return o, nil
+}
@@ -0,0 +1,225 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	type testcase struct {
+		casename string
+		expr     string
+		expected []string /* path and DataStr of each match */
+	}
+
+	record := func(log string, level string, code int64) *MPObject {
+		return NewMap([]*MPObject{
+			NewStr("log"), NewStr(log),
+			NewStr("level"), NewStr(level),
+			NewStr("code"), NewInt(code),
+		})
+	}
+	/* Fluentd Forward mode: ["tag", [[1, {...}], [2, {...}], [3, {...}]], {"size":3, 1:true}] */
+	obj := NewArray([]*MPObject{
+		NewStr("tag"),
+		NewArray([]*MPObject{
+			NewArray([]*MPObject{NewInt(1), record("a", "error", 500)}),
+			NewArray([]*MPObject{NewInt(2), record("b", "info", 200)}),
+			NewArray([]*MPObject{NewInt(3), record("c b", "warn", 404)}),
+		}),
+		NewMap([]*MPObject{NewStr("size"), NewInt(3), NewInt(1), NewBool(true)}),
+	})
+
+	cases := []testcase{
+		{"root", "$", []string{"$ (fixarray)"}},
+		{"index", "$[0]", []string{"$[0] tag"}},
+		{"negative index", "$[-1].size", []string{"$[2].size 3"}},
+		{"out of range", "$[3]", []string{}},
+		{"wildcard", "$[1][*][1].log", []string{"$[1][0][1].log a", "$[1][1][1].log b", "$[1][2][1].log c b"}},
+		{"dot wildcard", "$[2].*", []string{"$[2].size 3", "$[2][1] true"}},
+		{"quoted key", `$[2]["size"]`, []string{"$[2].size 3"}},
+		{"single quoted key", `$[2]['size']`, []string{"$[2].size 3"}},
+		{"non-string key", "$[2][1]", []string{"$[2][1] true"}},
+		{"string key is not index", `$[2]["1"]`, []string{}},
+		{"recursive", "$..log", []string{"$[1][0][1].log a", "$[1][1][1].log b", "$[1][2][1].log c b"}},
+		{"recursive index", "$[1]..[0]", []string{"$[1][0] (fixarray)", "$[1][0][0] 1", "$[1][1][0] 2", "$[1][2][0] 3"}},
+		{"slice", "$[1][1:][0]", []string{"$[1][1][0] 2", "$[1][2][0] 3"}},
+		{"slice end", "$[1][:-2][0]", []string{"$[1][0][0] 1"}},
+		{"slice step", "$[1][::2][0]", []string{"$[1][0][0] 1", "$[1][2][0] 3"}},
+		{"slice reverse", "$[1][::-1][0]", []string{"$[1][2][0] 3", "$[1][1][0] 2", "$[1][0][0] 1"}},
+		{"filter string", `$[1][*][?(@.level == "error")].log`, []string{"$[1][0][1].log a"}},
+		{"filter number", `$[1][*][?(@.code >= 404)].code`, []string{"$[1][0][1].code 500", "$[1][2][1].code 404"}},
+		{"filter and", `$[1][*][?(@.code > 200 && @.level != 'error')].log`, []string{"$[1][2][1].log c b"}},
+		{"filter or", `$[1][*][?(@.log == "a" || (@.log == "b"))].log`, []string{"$[1][0][1].log a", "$[1][1][1].log b"}},
+		{"filter not", `$[1][?(!(@[1].code < 300))][0]`, []string{"$[1][0][0] 1", "$[1][2][0] 3"}},
+		{"filter self", `$[1][?(@[0] == 2)][1].level`, []string{"$[1][1][1].level info"}},
+		{"filter scalar", `$[1][*][*][?(@ == "b")]`, []string{"$[1][1][1].log b"}},
+		{"filter exists", `$[?(@.size)]`, []string{"$[2] (fixmap)"}},
+		{"filter bool", `$[2][?(@ == true)]`, []string{"$[2][1] true"}},
+		{"filter type mismatch", `$[1][*][1][?(@ == 500)]`, []string{"$[1][0][1].code 500"}},
+		{"recursive filter", `$..[?(@.code == 200)].log`, []string{"$[1][1][1].log b"}},
+	}
+
+	for _, v := range cases {
+		matches, err := Query(obj, v.expr)
+		if err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+			continue
+		}
+		given := []string{}
+		for _, m := range matches {
+			given = append(given, m.Path.String()+" "+m.Obj.DataStr)
+		}
+		if !reflect.DeepEqual(given, v.expected) {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", v.casename, given, v.expected)
+		}
+	}
+}
+
+func TestQueryFloat(t *testing.T) {
+	type testcase struct {
+		casename string
+		expr     string
+		expected []string /* path of each match */
+	}
+
+	v := func(obj *MPObject) *MPObject {
+		return NewMap([]*MPObject{NewStr("v"), obj})
+	}
+	obj := NewArray([]*MPObject{v(NewFloat32(0.1)), v(NewFloat64(0.1)), v(NewFloat64(0.5)), v(NewInt(1)), v(NewFloat32(16777217))})
+
+	cases := []testcase{
+		{"equal", `$[?(@.v == 0.1)]`, []string{"$[0]", "$[1]"}},
+		{"not equal", `$[?(@.v != 0.1)]`, []string{"$[2]", "$[3]", "$[4]"}},
+		{"greater", `$[?(@.v > 0.1)]`, []string{"$[2]", "$[3]", "$[4]"}},
+		{"less or equal", `$[?(@.v <= 0.1)]`, []string{"$[0]", "$[1]"}},
+		{"fraction", `$[?(@.v == 0.50)]`, []string{"$[2]"}},
+		{"exponent", `$[?(@.v < 1e-1)]`, []string{}},
+		{"integer", `$[?(@.v == 1.0)]`, []string{"$[3]"}},
+		{"rounded float 32", `$[?(@.v == 16777217)]`, []string{"$[4]"}},
+	}
+
+	for _, c := range cases {
+		matches, err := Query(obj, c.expr)
+		if err != nil {
+			t.Errorf("%s: err=%v", c.casename, err)
+			continue
+		}
+		given := []string{}
+		for _, m := range matches {
+			given = append(given, m.Path.String())
+		}
+		if !reflect.DeepEqual(given, c.expected) {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", c.casename, given, c.expected)
+		}
+	}
+}
+
+func TestQueryOrder(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		expr     string
+		expected []string /* path and DataStr of each match */
+	}
+
+	cases := []testcase{
+		/* {"a":{"log":1},"log":2} */
+		{"recursive key", []byte{0x82, 0xa1, 0x61, 0x81, 0xa3, 0x6c, 0x6f, 0x67, 0x01, 0xa3, 0x6c, 0x6f, 0x67, 0x02}, "$..log",
+			[]string{"$.a.log 1", "$.log 2"}},
+		/* [[1],2] */
+		{"recursive wildcard", []byte{0x92, 0x91, 0x01, 0x02}, "$..*", []string{"$[0] (fixarray)", "$[0][0] 1", "$[1] 2"}},
+		{"reverse after recursive", []byte{0x92, 0x91, 0x01, 0x92, 0x02, 0x03}, "$..[::-1]",
+			[]string{"$[0] (fixarray)", "$[0][0] 1", "$[1] (fixarray)", "$[1][0] 2", "$[1][1] 3"}},
+	}
+
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		matches, err := Query(obj, v.expr)
+		if err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+			continue
+		}
+		given := []string{}
+		for i, m := range matches {
+			given = append(given, m.Path.String()+" "+m.Obj.DataStr)
+			if i > 0 && m.Obj.Offset <= matches[i-1].Obj.Offset {
+				t.Errorf("%s: %s is not in document order", v.casename, m.Path)
+			}
+		}
+		if !reflect.DeepEqual(given, v.expected) {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", v.casename, given, v.expected)
+		}
+	}
+}
+
+func TestQueryPath(t *testing.T) {
+	/* Path.String of each object is a query which selects the object. */
+	obj := NewMap([]*MPObject{
+		NewStr("a b"), NewArray([]*MPObject{NewStr("x"), NewMap([]*MPObject{NewInt(-1), NewStr("y")})}),
+		NewStr("c\"'"), NewNil(),
+		NewBool(false), NewStr("z"),
+	})
+
+	count := 0
+	Walk(obj, func(path Path, o *MPObject) error {
+		count++
+		matches, err := Query(obj, path.String())
+		if err != nil {
+			t.Errorf("%s: err=%v", path, err)
+		} else if len(matches) != 1 || matches[0].Obj != o || matches[0].Path.String() != path.String() {
+			t.Errorf("%s: mismatch. given=%v", path, matches)
+		}
+		return nil
+	})
+	if count != 7 {
+		t.Errorf("count mismatch. given=%d", count)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	cases := []string{
+		"",
+		"a",
+		"$.",
+		"$[",
+		"$[0",
+		"$[\"a]",
+		"$[1:2:3:4]",
+		"$[::0]",
+		"$[a:b]",
+		"$[?(@.a ==)]",
+		"$[?(@.a == 1]",
+		"$[?(1)]",
+		"$[?(@[*] == 1)]",
+		"$[?(@.a == x)]",
+		"$x",
+	}
+
+	for _, v := range cases {
+		if _, err := CompileQuery(v); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import "bufio"
+
+// indentWriter indents JSON written to it like json.Indent with an empty prefix.
+// Unlike json.Indent it does not need the whole JSON, so a deep object is written as it is rendered.
+type indentWriter struct {
+	out      *bufio.Writer
+	indent   string
+	depth    int
+	inString bool
+	escaped  bool /* the previous byte in the string is a backslash */
+	open     bool /* '[' or '{' is written and the newline after it is not written yet */
+}
+
+// Write implements io.Writer.
+func (w *indentWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if w.inString {
+			w.out.WriteByte(c)
+			switch {
+			case w.escaped:
+				w.escaped = false
+			case c == '\\':
+				w.escaped = true
+			case c == '"':
+				w.inString = false
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		if w.open {
+			w.open = false
+			if c == ']' || c == '}' {
+				/* empty array and map stay on a line */
+				w.depth--
+				w.out.WriteByte(c)
+				continue
+			}
+			w.newline()
+		}
+		switch c {
+		case '"':
+			w.inString = true
+			w.out.WriteByte(c)
+		case '[', '{':
+			w.depth++
+			w.open = true
+			w.out.WriteByte(c)
+		case ']', '}':
+			w.depth--
+			w.newline()
+			w.out.WriteByte(c)
+		case ',':
+			w.out.WriteByte(c)
+			w.newline()
+		case ':':
+			w.out.WriteString(": ")
+		default:
+			w.out.WriteByte(c)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *indentWriter) newline() {
+	w.out.WriteByte('\n')
+	for i := 0; i < w.depth; i++ {
+		w.out.WriteString(w.indent)
+	}
+}
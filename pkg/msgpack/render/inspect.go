@@ -0,0 +1,246 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+/* Functions to output the schema of msgpack-inspect. https://github.com/tagomoris/msgpack-inspect */
+
+// inspectFormats are the format names of msgpack-inspect for 0xc0 to 0xdf.
+var inspectFormats = [...]string{
+	"nil", "never_used", "false", "true", "bin8", "bin16", "bin32", "ext8", "ext16", "ext32", "float32", "float64",
+	"uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64",
+	"fixext1", "fixext2", "fixext4", "fixext8", "fixext16", "str8", "str16", "str32", "array16", "array32", "map16", "map32",
+}
+
+// inspectFormat returns the format name of msgpack-inspect. e.g. "fixint" for positive and negative fixint.
+func inspectFormat(b byte) string {
+	switch {
+	case b <= 0x7f || b >= 0xe0:
+		return "fixint"
+	case b <= 0x8f:
+		return "fixmap"
+	case b <= 0x9f:
+		return "fixarray"
+	case b <= 0xbf:
+		return "fixstr"
+	}
+	return inspectFormats[b-msgpack.NilFormat]
+}
+
+// inspectField is a field of an object in the schema of msgpack-inspect.
+type inspectField struct {
+	name  string
+	value string /* JSON or YAML of the value */
+}
+
+// inspectFields returns the fields of obj except children.
+// The values are YAML if yaml is true, otherwise JSON.
+func inspectFields(obj *msgpack.MPObject, opts *Options, yaml bool) []inspectField {
+	q := func(s string) string {
+		if yaml {
+			return yamlQuote(s)
+		}
+		return msgpack.QuoteJSON(s, false)
+	}
+	ret := []inspectField{{"format", q(inspectFormat(obj.FirstByte))}, {"header", fmt.Sprintf(`"0x%02x"`, obj.FirstByte)}}
+	if msgpack.IsExt(obj.FirstByte) {
+		ret = append(ret, inspectField{"exttype", strconv.Itoa(int(obj.ExtType))})
+	}
+	if isCollection(obj) {
+		return append(ret, inspectField{"length", fmt.Sprint(obj.Length)})
+	}
+
+	data := payload(obj)
+	if msgpack.IsString(obj.FirstByte) || msgpack.IsBin(obj.FirstByte) || msgpack.IsExt(obj.FirstByte) {
+		ret = append(ret, inspectField{"length", strconv.Itoa(len(data))})
+	} else if len(data) == 0 {
+		/* the value is in the header. e.g. fixint and true */
+		data = []byte{obj.FirstByte}
+	}
+	ret = append(ret, inspectField{"data", fmt.Sprintf(`"0x%x"`, data)})
+
+	var v string
+	switch {
+	case obj.FirstByte == msgpack.NilFormat || obj.FirstByte == msgpack.NeverUsedFormat:
+		v = "null"
+	case msgpack.IsString(obj.FirstByte) && yaml:
+		if obj.InvalidUTF8 != nil && opts.UTF8 == UTF8Hex {
+			v = fmt.Sprintf(`"0x%x"`, obj.DataStr)
+		} else {
+			v = yamlQuote(strings.ToValidUTF8(obj.DataStr, "\ufffd"))
+		}
+	case msgpack.IsString(obj.FirstByte):
+		v = strValue(obj, opts)
+	case msgpack.IsBin(obj.FirstByte):
+		v = q(obj.DataStr)
+	case msgpack.IsExt(obj.FirstByte):
+		v = q(timeStr(obj, opts))
+	case obj.FirstByte == msgpack.Float32Format || obj.FirstByte == msgpack.Float64Format:
+		if yaml {
+			v = yamlFloat(obj)
+		} else {
+			v = floatValue(obj, opts)
+		}
+	case isInteger(obj) && !yaml:
+		v = intValue(obj, opts)
+	default:
+		v = obj.DataStr
+	}
+	if v == "" && !yaml {
+		/* broken object has no value. YAML reads an empty value as null */
+		v = "null"
+	}
+	return append(ret, inspectField{"value", v})
+}
+
+// payload returns the encoded bytes of scalar obj after the header.
+func payload(obj *msgpack.MPObject) []byte {
+	raw := obj.Raw
+	if len(raw) == 0 {
+		/* obj is not decoded from the input */
+		buf := &bytes.Buffer{}
+		if msgpack.Encode(buf, obj) != nil {
+			return nil
+		}
+		raw = buf.Bytes()
+	}
+	if len(raw) < obj.HeaderSize {
+		return nil
+	}
+	return raw[obj.HeaderSize:]
+}
+
+// yamlQuote returns s as double-quoted scalar of YAML.
+// The escape sequences of Go are also the escape sequences of YAML.
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+// yamlFloat returns YAML of float object. It has a decimal point, so YAML 1.1 parsers read it as float.
+func yamlFloat(obj *msgpack.MPObject) string {
+	v, _ := obj.Float()
+	switch {
+	case math.IsNaN(v):
+		return ".NaN"
+	case math.IsInf(v, 1):
+		return ".Inf"
+	case math.IsInf(v, -1):
+		return "-.Inf"
+	case strings.Contains(obj.DataStr, "."):
+		return obj.DataStr
+	}
+	if i := strings.IndexByte(obj.DataStr, 'e'); i >= 0 {
+		return obj.DataStr[:i] + ".0" + obj.DataStr[i:]
+	}
+	return obj.DataStr + ".0"
+}
+
+// writeInspectJSON outputs obj and its children as JSON of msgpack-inspect.
+func writeInspectJSON(out io.Writer, obj *msgpack.MPObject, opts *Options) {
+	msgpack.Traverse(obj, func(v msgpack.Visit) {
+		isMap := msgpack.IsMap(v.Obj.FirstByte)
+		if v.Leave {
+			if isMap && len(v.Obj.Child) >= 2 {
+				/* end of the last key-value pair */
+				fmt.Fprint(out, "}")
+			}
+			fmt.Fprint(out, "]}")
+			return
+		}
+
+		if v.Parent != nil {
+			switch {
+			case msgpack.IsMap(v.Parent.FirstByte) && v.Index%2 == 1:
+				fmt.Fprint(out, `,"value":`)
+			case msgpack.IsMap(v.Parent.FirstByte) && v.Index > 0:
+				/* end of the previous key-value pair */
+				fmt.Fprint(out, `},{"key":`)
+			case msgpack.IsMap(v.Parent.FirstByte):
+				fmt.Fprint(out, `{"key":`)
+			case v.Index > 0:
+				fmt.Fprint(out, ",")
+			}
+		}
+		fmt.Fprint(out, "{")
+		for i, f := range inspectFields(v.Obj, opts, false) {
+			if i > 0 {
+				fmt.Fprint(out, ",")
+			}
+			fmt.Fprintf(out, `"%s":%s`, f.name, f.value)
+		}
+		if isCollection(v.Obj) {
+			fmt.Fprint(out, `,"children":[`)
+		} else {
+			fmt.Fprint(out, "}")
+		}
+	})
+}
+
+// writeInspectYAML outputs obj and its children as an item of YAML sequence of msgpack-inspect.
+func writeInspectYAML(out io.Writer, obj *msgpack.MPObject, opts *Options) {
+	nests := []int{} /* nesting levels of the containers being output */
+	msgpack.Traverse(obj, func(v msgpack.Visit) {
+		if v.Leave {
+			nests = nests[:len(nests)-1]
+			return
+		}
+
+		nest := 1
+		if v.Parent == nil {
+			fmt.Fprint(out, "- ")
+		} else {
+			parent := nests[len(nests)-1]
+			spaces := strings.Repeat("  ", parent+1)
+			nest = parent + 3
+			switch {
+			case !msgpack.IsMap(v.Parent.FirstByte):
+				fmt.Fprintf(out, "\n%s- ", spaces)
+				nest = parent + 2
+			case v.Index%2 == 0:
+				fmt.Fprintf(out, "\n%s- key:\n%s", spaces, strings.Repeat("  ", nest))
+			default:
+				fmt.Fprintf(out, "\n%s  value:\n%s", spaces, strings.Repeat("  ", nest))
+			}
+		}
+
+		spaces := strings.Repeat("  ", nest)
+		for i, f := range inspectFields(v.Obj, opts, true) {
+			if i > 0 {
+				fmt.Fprintf(out, "\n%s", spaces)
+			}
+			fmt.Fprintf(out, "%s: %s", f.name, f.value)
+		}
+		if isCollection(v.Obj) {
+			if len(v.Obj.Child) == 0 || msgpack.IsMap(v.Obj.FirstByte) && len(v.Obj.Child) < 2 {
+				fmt.Fprintf(out, "\n%schildren: []", spaces)
+			} else {
+				fmt.Fprintf(out, "\n%schildren:", spaces)
+			}
+			nests = append(nests, nest)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+func TestInspectFormat(t *testing.T) {
+	type testcase struct {
+		casename string
+		b        byte
+		expected string
+	}
+
+	cases := []testcase{
+		{"positive fixint", 0x7f, "fixint"},
+		{"negative fixint", 0xe0, "fixint"},
+		{"fixmap", 0x8f, "fixmap"},
+		{"fixarray", 0x90, "fixarray"},
+		{"fixstr", 0xbf, "fixstr"},
+		{"nil", 0xc0, "nil"},
+		{"never used", 0xc1, "never_used"},
+		{"uint 8", 0xcc, "uint8"},
+		{"fixext 16", 0xd8, "fixext16"},
+		{"map 32", 0xdf, "map32"},
+	}
+
+	for _, v := range cases {
+		if ret := inspectFormat(v.b); ret != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, ret, v.expected)
+		}
+	}
+}
+
+func TestInspectJSON(t *testing.T) {
+	type testcase struct {
+		casename string
+		msgpdata []byte
+		opts     *Options
+		expected string
+	}
+
+	cases := []testcase{
+		{"fixint", []byte{0x01}, &Options{}, `{"format":"fixint","header":"0x01","data":"0x01","value":1}`},
+		{"uint 8", []byte{0xcc, 0xff}, &Options{}, `{"format":"uint8","header":"0xcc","data":"0xff","value":255}`},
+		{"str 8", []byte{0xd9, 0x02, 0x41, 0x22}, &Options{}, `{"format":"str8","header":"0xd9","length":2,"data":"0x4122","value":"A\""}`},
+		{"invalid UTF-8", []byte{0xa2, 0x41, 0xff}, &Options{UTF8: UTF8Hex}, `{"format":"fixstr","header":"0xa2","length":2,"data":"0x41ff","value":"0x41ff"}`},
+		{"bin 8", []byte{0xc4, 0x01, 0xff}, &Options{}, `{"format":"bin8","header":"0xc4","length":1,"data":"0xff","value":"0xff"}`},
+		{"ext", []byte{0xd5, 0x01, 0xfe, 0xed}, &Options{}, `{"format":"fixext2","header":"0xd5","exttype":1,"length":2,"data":"0xfeed","value":"0xfeed"}`},
+		{"timestamp", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, &Options{Time: &msgpack.TimeFormat{Layout: msgpack.TimeUnix}},
+			`{"format":"fixext4","header":"0xd6","exttype":-1,"length":4,"data":"0x00000001","value":"1"}`},
+		{"float 32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, &Options{}, `{"format":"float32","header":"0xca","data":"0x3fc00000","value":1.5}`},
+		{"NaN", []byte{0xca, 0x7f, 0xc0, 0x00, 0x00}, &Options{NonFinite: NonFiniteString}, `{"format":"float32","header":"0xca","data":"0x7fc00000","value":"NaN"}`},
+		{"int 64", []byte{0xd3, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, &Options{Int64: Int64String}, `{"format":"int64","header":"0xd3","data":"0x0020000000000001","value":"9007199254740993"}`},
+		{"array", []byte{0x92, 0xc0, 0x90}, &Options{},
+			`{"format":"fixarray","header":"0x92","length":2,"children":[{"format":"nil","header":"0xc0","data":"0xc0","value":null},{"format":"fixarray","header":"0x90","length":0,"children":[]}]}`},
+		{"map", []byte{0x82, 0xa1, 0x41, 0xc3, 0x01, 0x80}, &Options{},
+			`{"format":"fixmap","header":"0x82","length":2,"children":[{"key":{"format":"fixstr","header":"0xa1","length":1,"data":"0x41","value":"A"},"value":{"format":"true","header":"0xc3","data":"0xc3","value":true}},` +
+				`{"key":{"format":"fixint","header":"0x01","data":"0x01","value":1},"value":{"format":"fixmap","header":"0x80","length":0,"children":[]}}]}`},
+	}
+
+	for _, v := range cases {
+		obj, err := msgpack.Decode(bytes.NewBuffer(v.msgpdata))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		v.opts.Inspect = InspectJSON
+		ret, err := Marshal(obj, v.opts)
+		if err != nil {
+			t.Errorf("%s: Marshal error %s", v.casename, err)
+			continue
+		}
+		if string(ret) != v.expected {
+			t.Errorf("%s: mismatch.\n given: %s\n expected: %s", v.casename, ret, v.expected)
+		}
+		if !json.Valid(ret) {
+			t.Errorf("%s: invalid JSON %s", v.casename, ret)
+		}
+	}
+
+	/* the object which is not decoded from the input */
+	ret, err := Marshal(msgpack.NewUint(256), &Options{Inspect: InspectJSON})
+	if err != nil || string(ret) != `{"format":"uint16","header":"0xcd","data":"0x0100","value":256}` {
+		t.Errorf("builder: mismatch. given: %s err=%v", ret, err)
+	}
+
+	/* the broken object has no value */
+	obj, _ := msgpack.Decode(bytes.NewBuffer([]byte{0xd3, 0xff, 0x5c, 0xa1}))
+	ret, err = Marshal(obj, &Options{Inspect: InspectJSON})
+	if err != nil || string(ret) != `{"format":"int64","header":"0xd3","data":"0xff5ca1","value":null}` {
+		t.Errorf("truncated: mismatch. given: %s err=%v", ret, err)
+	}
+}
+
+func TestInspectYAML(t *testing.T) {
+	/* {"A":[1,{}],"B":[]} */
+	b := []byte{0x82, 0xa1, 0x41, 0x92, 0x01, 0x80, 0xa1, 0x42, 0x90}
+	expected := `- format: "fixmap"
+  header: "0x82"
+  length: 2
+  children:
+    - key:
+        format: "fixstr"
+        header: "0xa1"
+        length: 1
+        data: "0x41"
+        value: "A"
+      value:
+        format: "fixarray"
+        header: "0x92"
+        length: 2
+        children:
+          - format: "fixint"
+            header: "0x01"
+            data: "0x01"
+            value: 1
+          - format: "fixmap"
+            header: "0x80"
+            length: 0
+            children: []
+    - key:
+        format: "fixstr"
+        header: "0xa1"
+        length: 1
+        data: "0x42"
+        value: "B"
+      value:
+        format: "fixarray"
+        header: "0x90"
+        length: 0
+        children: []`
+
+	obj, err := msgpack.Decode(bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	ret, err := Marshal(obj, &Options{Inspect: InspectYAML})
+	if err != nil {
+		t.Fatalf("Marshal error %s", err)
+	}
+	if string(ret) != expected {
+		t.Errorf("mismatch.\n given: %s\n expected: %s", ret, expected)
+	}
+
+	/* str is escaped and invalid UTF-8 is replaced */
+	ret, _ = Marshal(msgpack.NewStr("a\"\n\x00\x7f\xff"), &Options{Inspect: InspectYAML})
+	if !strings.HasSuffix(string(ret), "value: \"a\\\"\\n\\x00\\x7f\ufffd\"") {
+		t.Errorf("str: mismatch. given: %s", ret)
+	}
+}
+
+func TestYAMLFloat(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *msgpack.MPObject
+		expected string
+	}
+
+	cases := []testcase{
+		{"fraction", msgpack.NewFloat64(1.5), "1.5"},
+		{"integer", msgpack.NewFloat64(1), "1.0"},
+		{"negative zero", msgpack.NewFloat64(math.Copysign(0, -1)), "-0.0"},
+		{"exponent", msgpack.NewFloat64(1e21), "1.0e+21"},
+		{"fraction and exponent", msgpack.NewFloat64(1.5e-7), "1.5e-07"},
+		{"float 32", msgpack.NewFloat32(0.1), "0.1"},
+		{"NaN", msgpack.NewFloat64(math.NaN()), ".NaN"},
+		{"infinity", msgpack.NewFloat64(math.Inf(1)), ".Inf"},
+		{"negative infinity", msgpack.NewFloat32(float32(math.Inf(-1))), "-.Inf"},
+	}
+
+	for _, v := range cases {
+		if ret := yamlFloat(v.obj); ret != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, ret, v.expected)
+		}
+	}
+}
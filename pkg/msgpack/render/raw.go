@@ -0,0 +1,62 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+/* Functions to output plain JSON. */
+
+// writeRaw outputs obj and its children as plain JSON by msgpack.WriteJSON with the policies of opts.
+func writeRaw(out io.Writer, obj *msgpack.MPObject, opts *Options) {
+	/* KeysError is checked by Write and the other policies are the ones of msgpack.JSONOptions */
+	jopts := &msgpack.JSONOptions{Keys: opts.Keys, Value: func(v *msgpack.MPObject) string {
+		return rawValue(v, opts)
+	}}
+	if opts.ErrorLog != nil {
+		jopts.Duplicate = func(m *msgpack.MPObject, key string) {
+			fmt.Fprintf(opts.ErrorLog, "Warning: keys of map at offset %d are converted into the same key %s\n", m.Offset, key)
+		}
+	}
+	msgpack.WriteJSON(out, obj, jopts)
+}
+
+// rawValue returns plain JSON of obj which is not array or map.
+func rawValue(obj *msgpack.MPObject, opts *Options) string {
+	switch {
+	case msgpack.IsString(obj.FirstByte):
+		return strValue(obj, opts)
+	case msgpack.IsBin(obj.FirstByte):
+		return binValue(obj, opts)
+	case msgpack.IsExt(obj.FirstByte):
+		if v, ok := extValue(obj, opts); ok {
+			return v
+		}
+		return binValue(obj, opts)
+	case msgpack.NilFormat == obj.FirstByte, msgpack.NeverUsedFormat == obj.FirstByte:
+		return "null"
+	case obj.FirstByte == msgpack.Float32Format || obj.FirstByte == msgpack.Float64Format:
+		return floatValue(obj, opts)
+	case isInteger(obj):
+		return intValue(obj, opts)
+	}
+	return obj.DataStr
+}
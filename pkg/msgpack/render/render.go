@@ -0,0 +1,240 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package render converts MPObject into JSON like msgpack2json.
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+// Values of Options.UTF8.
+const (
+	UTF8Replace = "replace" /* replace invalid bytes with U+FFFD */
+	UTF8Escape  = "escape"  /* replace invalid bytes with \ufffd escape sequence */
+	UTF8Hex     = "hex"     /* show the whole payload as hex like "0x41ff42" */
+)
+
+// Values of Options.NonFinite.
+const (
+	NonFiniteNull   = "null"   /* null */
+	NonFiniteString = "string" /* "NaN", "+Inf" or "-Inf" */
+	NonFiniteError  = "error"  /* Write returns an error */
+)
+
+// Values of Options.Keys.
+const (
+	KeysString = msgpack.JSONKeysString /* stringify scalar keys and use JSON text of array and map keys. e.g. {"1":2} */
+	KeysJSON   = msgpack.JSONKeysJSON   /* use JSON text of every key. e.g. {"$json_keys":{"1":2,"\"a\"":3}} */
+	KeysPairs  = msgpack.JSONKeysPairs  /* an array of key-value pairs. e.g. {"$pairs":[[1,2],["a",3]]} */
+	KeysError  = "error"                /* Write returns an error */
+)
+
+// Values of Options.Int64.
+const (
+	Int64Number = "number" /* a number */
+	Int64Safe   = "safe"   /* a string if the integer is out of -(2^53-1) to 2^53-1 */
+	Int64String = "string" /* a string for int 64 and uint 64 format */
+	Int64Long   = "long"   /* {"$numberLong":"..."} for int 64 and uint 64 format */
+)
+
+// Values of Options.Bin.
+const (
+	BinHex       = "hex"       /* "0xdeadbeef" */
+	BinBase64    = "base64"    /* "3q2+7w==" */
+	BinBase64URL = "base64url" /* "3q2-7w==" */
+	BinTagged    = "tagged"    /* {"$bin":"3q2+7w==","encoding":"base64"} and {"$ext":1,"data":"3q2+7w=="} */
+)
+
+// Values of Options.Inspect.
+const (
+	InspectJSON = "json" /* JSON of msgpack-inspect -f json */
+	InspectYAML = "yaml" /* YAML of msgpack-inspect -f yaml. Write outputs an item of YAML sequence */
+)
+
+// Options is a policy to convert MPObject into JSON.
+// The zero value outputs raw JSON which is the same as json.Marshal of MPObject.
+// Empty policy means the first value of the constants.
+type Options struct {
+	Verbose bool   /* output the format, offset and raw bytes of each object like msgpack2json */
+	Indent  string /* indent of a nesting level. raw JSON is compact and verbose JSON uses four spaces if empty */
+	Inspect string /* output the schema of msgpack-inspect instead, InspectJSON or InspectYAML. YAML is always indented with two spaces */
+
+	UTF8      string /* how to show str which is not valid UTF-8. UTF8Replace, UTF8Escape or UTF8Hex */
+	NonFinite string /* how to show NaN and infinity of float. NonFiniteNull, NonFiniteString or NonFiniteError */
+	Keys      string /* how to show map which has keys other than str in raw JSON. KeysString, KeysJSON, KeysPairs or KeysError */
+	Int64     string /* how to show integer which JSON parsers may round. Int64Number, Int64Safe, Int64String or Int64Long */
+	Bin       string /* how to show bin and ext which is not decoded in raw JSON. BinHex, BinBase64, BinBase64URL or BinTagged */
+
+	Time *msgpack.TimeFormat  /* how to show time. nil means {"sec":...,"nsec":...} in raw JSON and DataStr in verbose JSON */
+	Exts *msgpack.ExtRegistry /* registry which decoded the object. nil means the default registry */
+
+	ErrorLog io.Writer /* where to report broken objects, e.g. an array with fewer elements, and keys converted into the same key. nil discards */
+}
+
+// Validate returns an error if the options have an unknown policy.
+// Write calls it, so use it to check the options before writing anything else, e.g. command line flags.
+func (o *Options) Validate() error {
+	policies := []struct {
+		name  string
+		value string
+		valid []string
+	}{
+		{"UTF8", o.UTF8, []string{UTF8Replace, UTF8Escape, UTF8Hex}},
+		{"NonFinite", o.NonFinite, []string{NonFiniteNull, NonFiniteString, NonFiniteError}},
+		{"Keys", o.Keys, []string{KeysString, KeysJSON, KeysPairs, KeysError}},
+		{"Int64", o.Int64, []string{Int64Number, Int64Safe, Int64String, Int64Long}},
+		{"Bin", o.Bin, []string{BinHex, BinBase64, BinBase64URL, BinTagged}},
+		{"Inspect", o.Inspect, []string{InspectJSON, InspectYAML}},
+	}
+	for _, p := range policies {
+		ok := p.value == ""
+		for _, v := range p.valid {
+			ok = ok || p.value == v
+		}
+		if !ok {
+			return fmt.Errorf("unknown %s option %q", p.name, p.value)
+		}
+	}
+	return nil
+}
+
+// Write writes obj as JSON, or YAML with InspectYAML, to w without a trailing newline.
+// It returns an error without writing anything if obj can not be converted by the options,
+// e.g. NaN with NonFiniteError.
+func Write(w io.Writer, obj *msgpack.MPObject, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.NonFinite == NonFiniteError {
+		if err := checkFinite(obj); err != nil {
+			return err
+		}
+	}
+	if !opts.Verbose && opts.Inspect == "" && opts.Keys == KeysError {
+		if err := checkKeys(obj); err != nil {
+			return err
+		}
+	}
+
+	logBroken(obj, opts)
+
+	/* write as rendering instead of building the whole output, which may be much larger than obj */
+	out := bufio.NewWriter(w)
+	switch {
+	case opts.Inspect == InspectYAML:
+		writeInspectYAML(out, obj, opts)
+	case opts.Verbose && opts.Inspect == "":
+		writeVerbose(out, obj, opts)
+	case opts.Inspect == InspectJSON && opts.Indent != "":
+		writeInspectJSON(&indentWriter{out: out, indent: opts.Indent}, obj, opts)
+	case opts.Inspect == InspectJSON:
+		writeInspectJSON(out, obj, opts)
+	case opts.Indent != "":
+		writeRaw(&indentWriter{out: out, indent: opts.Indent}, obj, opts)
+	default:
+		writeRaw(out, obj, opts)
+	}
+	return out.Flush()
+}
+
+// Marshal returns obj as JSON, or YAML with InspectYAML.
+func Marshal(obj *msgpack.MPObject, opts *Options) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := Write(buf, obj, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isNonFinite reports whether obj is float of NaN or infinity which JSON can not represent.
+func isNonFinite(obj *msgpack.MPObject) bool {
+	v, ok := obj.Float()
+	return ok && (math.IsNaN(v) || math.IsInf(v, 0))
+}
+
+// checkFinite returns an error if obj or its descendants have NaN or infinity.
+func checkFinite(obj *msgpack.MPObject) error {
+	return msgpack.Walk(obj, func(path msgpack.Path, v *msgpack.MPObject) error {
+		if isNonFinite(v) {
+			return fmt.Errorf("%s at %s can not be represented in JSON", v.DataStr, path)
+		}
+		if msgpack.IsMap(v.FirstByte) {
+			/* Walk does not visit keys */
+			for i := 0; i+1 < len(v.Child); i += 2 {
+				if isNonFinite(v.Child[i]) {
+					return fmt.Errorf("%s as a key at %s can not be represented in JSON", v.Child[i].DataStr, path)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// checkKeys returns an error if obj or its descendants have map whose key is not str.
+func checkKeys(obj *msgpack.MPObject) error {
+	return msgpack.Walk(obj, func(path msgpack.Path, v *msgpack.MPObject) error {
+		if key := nonStrKey(v); key != nil {
+			return fmt.Errorf("key of %s at %s can not be a key of JSON object", key.FormatName, path)
+		}
+		return nil
+	})
+}
+
+// nonStrKey returns the first key of map obj which is not str.
+// It returns nil if obj is not map or all keys are str.
+func nonStrKey(obj *msgpack.MPObject) *msgpack.MPObject {
+	if !msgpack.IsMap(obj.FirstByte) {
+		return nil
+	}
+	for i := 0; i+1 < len(obj.Child); i += 2 {
+		if !msgpack.IsString(obj.Child[i].FirstByte) {
+			return obj.Child[i]
+		}
+	}
+	return nil
+}
+
+// logBroken reports the broken objects in obj to opts.ErrorLog, e.g. an array with fewer elements.
+func logBroken(obj *msgpack.MPObject, opts *Options) {
+	if opts.ErrorLog == nil {
+		return
+	}
+	msgpack.Traverse(obj, func(v msgpack.Visit) {
+		switch {
+		case v.Leave:
+		case v.Obj.FirstByte == msgpack.NeverUsedFormat:
+			fmt.Fprintf(opts.ErrorLog, "Error: Never Used Format detected\n")
+		case msgpack.IsMap(v.Obj.FirstByte) && int(v.Obj.Length*2) != len(v.Obj.Child):
+			fmt.Fprintf(opts.ErrorLog, "Error: size mismatch. length is %d, buf %d(!=length*2) children.\n", v.Obj.Length, len(v.Obj.Child))
+		case msgpack.IsArray(v.Obj.FirstByte) && int(v.Obj.Length) != len(v.Obj.Child):
+			fmt.Fprintf(opts.ErrorLog, "Error: size mismatch. length is %d, buf %d children.\n", v.Obj.Length, len(v.Obj.Child))
+		}
+	})
+}
+
+func isCollection(obj *msgpack.MPObject) bool {
+	return msgpack.IsArray(obj.FirstByte) || msgpack.IsMap(obj.FirstByte)
+}
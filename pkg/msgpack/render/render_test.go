@@ -0,0 +1,133 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+func TestWrite(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *msgpack.MPObject
+		opts     *Options
+		expected string
+	}
+
+	obj := msgpack.NewArray([]*msgpack.MPObject{msgpack.NewInt(1), msgpack.NewMap([]*msgpack.MPObject{msgpack.NewStr("a"), msgpack.NewFloat64(0.5)})})
+	cases := []testcase{
+		{"nil options", obj, nil, `[1,{"a":0.5}]`},
+		{"zero options", obj, &Options{}, `[1,{"a":0.5}]`},
+		{"indent", obj, &Options{Indent: "  "}, "[\n  1,\n  {\n    \"a\": 0.5\n  }\n]"},
+		{"indent empty", msgpack.NewArray([]*msgpack.MPObject{msgpack.NewArray(nil), msgpack.NewMap(nil)}), &Options{Indent: "  "}, "[\n  [],\n  {}\n]"},
+		{"indent str", msgpack.NewMap([]*msgpack.MPObject{msgpack.NewStr(`[{"a": 1,`), msgpack.NewStr(`\"`)}), &Options{Indent: "  "}, "{\n  \"[{\\\"a\\\": 1,\": \"\\\\\\\"\"\n}"},
+		{"indent inspect", msgpack.NewInt(1), &Options{Inspect: InspectJSON, Indent: "  "}, "{\n  \"format\": \"fixint\",\n  \"header\": \"0x01\",\n  \"data\": \"0x01\",\n  \"value\": 1\n}"},
+		{"verbose", msgpack.NewInt(1), &Options{Verbose: true}, `{"format":"positive fixint", "header":"0x01", "offset":0, "size":0, "raw":"0x", "value":1}`},
+		{"verbose indent", msgpack.NewArray([]*msgpack.MPObject{msgpack.NewNil()}), &Options{Verbose: true, Indent: "\t"},
+			"{\"format\":\"fixarray\", \"header\":\"0x91\", \"offset\":0, \"size\":0, \"length\":1, \"raw\":\"0x\", \"value\":\n\t[\n\t\t{\"format\":\"nil\", \"header\":\"0xc0\", \"offset\":0, \"size\":0, \"raw\":\"0x\", \"value\":null}\n\t]\n}\n"},
+		{"policies", msgpack.NewArray([]*msgpack.MPObject{msgpack.NewFloat64(math.NaN()), msgpack.NewUint(1 << 60), msgpack.NewBin([]byte{0xff})}),
+			&Options{NonFinite: NonFiniteString, Int64: Int64String, Bin: BinBase64}, `["NaN","1152921504606846976","/w=="]`},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		if err := Write(&buf, v.obj, v.opts); err != nil {
+			t.Errorf("%s: Write error %s", v.casename, err)
+			continue
+		}
+		if buf.String() != v.expected {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", v.casename, buf.String(), v.expected)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	type testcase struct {
+		casename string
+		obj      *msgpack.MPObject
+		opts     *Options
+		errstr   string
+	}
+
+	nonStrKey := msgpack.NewArray([]*msgpack.MPObject{msgpack.NewMap([]*msgpack.MPObject{msgpack.NewInt(1), msgpack.NewNil()})})
+	cases := []testcase{
+		{"unknown policy", msgpack.NewNil(), &Options{Int64: "bigint"}, `unknown Int64 option "bigint"`},
+		{"non-finite", msgpack.NewArray([]*msgpack.MPObject{msgpack.NewFloat64(math.NaN())}), &Options{NonFinite: NonFiniteError}, "NaN at $[0]"},
+		{"keys", nonStrKey, &Options{Keys: KeysError}, "key of positive fixint at $[0]"},
+	}
+
+	buf := bytes.Buffer{}
+	for _, v := range cases {
+		buf.Reset()
+		err := Write(&buf, v.obj, v.opts)
+		if err == nil || !strings.Contains(err.Error(), v.errstr) {
+			t.Errorf("%s: error mismatch. given: %v expected: %s", v.casename, err, v.errstr)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: output %s", v.casename, buf.String())
+		}
+	}
+
+	/* keys of verbose JSON are objects, so any key is allowed */
+	if err := Write(&buf, nonStrKey, &Options{Verbose: true, Keys: KeysError}); err != nil {
+		t.Errorf("verbose: Write error %s", err)
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	/* an array which lost the second element */
+	obj := msgpack.NewArray([]*msgpack.MPObject{msgpack.NewInt(1)})
+	obj.Length = 2
+
+	log := bytes.Buffer{}
+	ret, err := Marshal(obj, &Options{ErrorLog: &log})
+	if err != nil {
+		t.Fatalf("Marshal error %s", err)
+	}
+	if string(ret) != "" {
+		/* raw JSON of the broken array is not output */
+		t.Errorf("mismatch. given: %s", ret)
+	}
+	if !strings.Contains(log.String(), "size mismatch") {
+		t.Errorf("size mismatch is not reported. given: %q", log.String())
+	}
+
+	/* keys converted into the same key */
+	log.Reset()
+	dup := msgpack.NewMap([]*msgpack.MPObject{msgpack.NewInt(1), msgpack.NewStr("a"), msgpack.NewStr("1"), msgpack.NewStr("b")})
+	if _, err := Marshal(dup, &Options{ErrorLog: &log}); err != nil {
+		t.Fatalf("Marshal error %s", err)
+	}
+	if !strings.Contains(log.String(), `the same key "1"`) {
+		t.Errorf("duplicated key is not reported. given: %q", log.String())
+	}
+	log.Reset()
+	if _, err := Marshal(dup, &Options{ErrorLog: &log, Keys: KeysPairs}); err != nil || log.Len() > 0 {
+		t.Errorf("pairs: unexpected report %q err=%v", log.String(), err)
+	}
+
+	/* nil ErrorLog discards */
+	if _, err := Marshal(obj, nil); err != nil {
+		t.Errorf("Marshal error %s", err)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+/* Functions to convert a value into JSON according to the options. */
+
+// strValue returns JSON string of str object.
+// Invalid UTF-8 is converted according to opts.UTF8.
+func strValue(obj *msgpack.MPObject, opts *Options) string {
+	if obj.InvalidUTF8 != nil && opts.UTF8 == UTF8Hex {
+		return fmt.Sprintf(`"0x%x"`, obj.DataStr)
+	}
+	return msgpack.QuoteJSON(obj.DataStr, opts.UTF8 == UTF8Escape)
+}
+
+// maxSafeInt is the largest integer n such that n and n+1 are exactly representable as float64.
+// It is Number.MAX_SAFE_INTEGER of JavaScript.
+const maxSafeInt = 1<<53 - 1
+
+// isInteger reports whether obj is int family, uint family or fixint.
+func isInteger(obj *msgpack.MPObject) bool {
+	switch obj.Value.(type) {
+	case int64, uint64:
+		return true
+	}
+	return false
+}
+
+// intValue returns JSON of integer object.
+// The integer which JSON parsers may round is shown according to opts.Int64.
+func intValue(obj *msgpack.MPObject, opts *Options) string {
+	is64 := obj.FirstByte == msgpack.Int64Format || obj.FirstByte == msgpack.Uint64Format
+	switch opts.Int64 {
+	case Int64Safe:
+		if v, ok := obj.Int(); !ok || v < -maxSafeInt || v > maxSafeInt {
+			return `"` + obj.DataStr + `"`
+		}
+	case Int64String:
+		if is64 {
+			return `"` + obj.DataStr + `"`
+		}
+	case Int64Long:
+		if is64 {
+			return `{"$numberLong":"` + obj.DataStr + `"}`
+		}
+	}
+	return obj.DataStr
+}
+
+// precisionLost reports whether the integer is rounded if it is parsed as float64 like JavaScript.
+func precisionLost(obj *msgpack.MPObject) bool {
+	f := new(big.Float)
+	switch v := obj.Value.(type) {
+	case int64:
+		f.SetInt64(v)
+	case uint64:
+		f.SetUint64(v)
+	default:
+		return false
+	}
+	_, acc := f.Float64()
+	return acc != big.Exact
+}
+
+// floatValue returns JSON of float object.
+// NaN and infinity are shown according to opts.NonFinite.
+func floatValue(obj *msgpack.MPObject, opts *Options) string {
+	if !isNonFinite(obj) {
+		return obj.DataStr
+	}
+	if opts.NonFinite == NonFiniteString {
+		return `"` + obj.DataStr + `"`
+	}
+	return "null"
+}
+
+// floatBits returns the IEEE 754 binary representation of float object as hex.
+func floatBits(obj *msgpack.MPObject) string {
+	switch v := obj.Value.(type) {
+	case float32:
+		return fmt.Sprintf("0x%08x", math.Float32bits(v))
+	case float64:
+		return fmt.Sprintf("0x%016x", math.Float64bits(v))
+	}
+	return ""
+}
+
+// timeStr returns the string of ext object. time.Time is shown by opts.Time if it is set.
+func timeStr(obj *msgpack.MPObject, opts *Options) string {
+	if t, ok := obj.Time(); ok && opts.Time != nil {
+		return opts.Time.Format(t)
+	}
+	return obj.DataStr
+}
+
+// extValue returns JSON of the typed value which the ext format decodes.
+// time.Time is shown by opts.Time if it is set.
+// ok is false if the payload is not decoded.
+func extValue(obj *msgpack.MPObject, opts *Options) (string, bool) {
+	if _, ok := obj.Value.(time.Time); ok && opts.Time != nil {
+		if opts.Time.IsNumber() {
+			return timeStr(obj, opts), true
+		}
+		return msgpack.QuoteJSON(timeStr(obj, opts), false), true
+	}
+	return decodedValue(obj, opts)
+}
+
+// decodedValue returns JSON of the typed value which the ext format decodes.
+// time.Time is shown as seconds and nanoseconds since the Unix epoch.
+func decodedValue(obj *msgpack.MPObject, opts *Options) (string, bool) {
+	switch v := obj.Value.(type) {
+	case nil, msgpack.Ext:
+		return "", false
+	case time.Time:
+		return fmt.Sprintf(`{"sec":%d,"nsec":%d}`, v.Unix(), v.Nanosecond()), true
+	case *msgpack.MPObject:
+		/* nested MessagePack */
+		b := &strings.Builder{}
+		writeRaw(b, v, opts)
+		return b.String(), true
+	}
+	b, err := json.Marshal(obj.Value)
+	if err != nil {
+		b, _ = json.Marshal(obj.DataStr)
+	}
+	return string(b), true
+}
+
+// binValue returns JSON of the payload of bin or ext which is not decoded according to opts.Bin.
+func binValue(obj *msgpack.MPObject, opts *Options) string {
+	data, ok := obj.Bytes()
+	if !ok {
+		return msgpack.QuoteJSON(obj.DataStr, false)
+	}
+	if msgpack.IsExt(obj.FirstByte) {
+		exts := opts.Exts
+		if exts == nil {
+			exts = msgpack.DefaultExtRegistry()
+		}
+		if f, ok := exts.Lookup(obj.FirstByte, obj.ExtType); ok && f.ValueFunc == nil {
+			/* DecodeFunc shows the payload as a string */
+			return msgpack.QuoteJSON(obj.DataStr, false)
+		}
+	}
+
+	switch opts.Bin {
+	case BinBase64:
+		return `"` + base64.StdEncoding.EncodeToString(data) + `"`
+	case BinBase64URL:
+		return `"` + base64.URLEncoding.EncodeToString(data) + `"`
+	case BinTagged:
+		/* the tag tells that the string is not str, so json2msgpack can restore the bytes */
+		if msgpack.IsExt(obj.FirstByte) {
+			return fmt.Sprintf(`{"$ext":%d,"data":"%s"}`, obj.ExtType, base64.StdEncoding.EncodeToString(data))
+		}
+		return fmt.Sprintf(`{"$bin":"%s","encoding":"base64"}`, base64.StdEncoding.EncodeToString(data))
+	}
+	return fmt.Sprintf(`"0x%x"`, data)
+}
@@ -0,0 +1,135 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nokute78/msgpack-microscope/pkg/msgpack"
+)
+
+/* Functions to output verbose JSON which has the format, offset and raw bytes of each object. */
+
+// indent returns the indent of the nesting level.
+func (o *Options) indent(nest int) string {
+	if o.Indent == "" {
+		return strings.Repeat("    ", nest)
+	}
+	return strings.Repeat(o.Indent, nest)
+}
+
+// writeVerbose outputs obj and its children.
+func writeVerbose(out io.Writer, obj *msgpack.MPObject, opts *Options) {
+	nests := []int{} /* nesting levels of the containers being output */
+	msgpack.Traverse(obj, func(v msgpack.Visit) {
+		if v.Leave {
+			nest := nests[len(nests)-1]
+			nests = nests[:len(nests)-1]
+			if msgpack.IsMap(v.Obj.FirstByte) {
+				if len(v.Obj.Child) >= 2 {
+					/* end of the last key-value pair */
+					fmt.Fprintf(out, "\n%s}", opts.indent(nest+2))
+				}
+				fmt.Fprintf(out, "\n%s]\n%s}", opts.indent(nest+1), opts.indent(nest))
+			} else {
+				fmt.Fprintf(out, "\n%s]\n%s}\n", opts.indent(nest+1), opts.indent(nest))
+			}
+			return
+		}
+
+		nest := 0
+		if v.Parent != nil {
+			parent := nests[len(nests)-1]
+			nest = parent + 3
+			switch {
+			case !msgpack.IsMap(v.Parent.FirstByte):
+				if v.Index > 0 {
+					fmt.Fprint(out, ",\n")
+				}
+				nest = parent + 2
+			case v.Index%2 == 0:
+				if v.Index > 0 {
+					/* end of the previous key-value pair */
+					fmt.Fprintf(out, "\n%s},\n", opts.indent(parent+2))
+				}
+				fmt.Fprintf(out, "%s{\"key\":\n", opts.indent(parent+2))
+			default:
+				fmt.Fprint(out, ",\n")
+				fmt.Fprintf(out, "%s \"value\":\n", opts.indent(parent+2))
+			}
+		}
+		writeVerboseObject(out, v.Obj, nest, opts)
+		if isCollection(v.Obj) {
+			nests = append(nests, nest)
+		}
+	})
+}
+
+// writeVerboseObject outputs obj. If obj is array or map, it outputs the header and the opening bracket.
+func writeVerboseObject(out io.Writer, obj *msgpack.MPObject, nest int, opts *Options) {
+	spaces := opts.indent(nest)
+
+	switch {
+	case isCollection(obj):
+		spaces2 := opts.indent(nest + 1)
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "length":%d, "raw":"0x%0x", "value":`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Length, obj.Raw)
+		fmt.Fprintf(out, "\n%s[\n", spaces2)
+	case msgpack.IsString(obj.FirstByte) && obj.InvalidUTF8 != nil:
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "invalid_utf8":%d, "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, obj.InvalidUTF8.Offset, strValue(obj, opts))
+	case msgpack.IsString(obj.FirstByte):
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, strValue(obj, opts))
+	case msgpack.IsBin(obj.FirstByte):
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "value":"%s"}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, obj.DataStr)
+	case msgpack.IsExt(obj.FirstByte):
+		/* the format name and the value may come from ExtFormat registered by the user */
+		fmt.Fprintf(out, `%s{"format":%s, "header":"0x%02x", "offset":%d, "size":%d, "type":%d, "raw":"0x%0x"%s, "value":%s}`, spaces, msgpack.QuoteJSON(obj.FormatName, false), obj.FirstByte, obj.Offset, obj.Size, obj.ExtType, obj.Raw, extFields(obj, opts), msgpack.QuoteJSON(timeStr(obj, opts), false))
+	case msgpack.NilFormat == obj.FirstByte, msgpack.NeverUsedFormat == obj.FirstByte:
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "value":null}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw)
+	case obj.FirstByte == msgpack.Float32Format || obj.FirstByte == msgpack.Float64Format:
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "bits":"%s", "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, floatBits(obj), floatValue(obj, opts))
+	case isInteger(obj):
+		v := intValue(obj, opts)
+		lost := ""
+		if v == obj.DataStr && precisionLost(obj) {
+			/* the value is shown as a number, but JSON parsers may round it */
+			lost = `, "precision_lost":true`
+		}
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x"%s, "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, lost, v)
+	default:
+		fmt.Fprintf(out, `%s{"format":"%s", "header":"0x%02x", "offset":%d, "size":%d, "raw":"0x%0x", "value":%s}`, spaces, obj.FormatName, obj.FirstByte, obj.Offset, obj.Size, obj.Raw, obj.DataStr)
+	}
+}
+
+// extFields returns the fields of verbose JSON for the decoded value and the errors of ext.
+func extFields(obj *msgpack.MPObject, opts *Options) string {
+	ret := ""
+	if v, ok := decodedValue(obj, opts); ok {
+		ret += fmt.Sprintf(`, "decoded":%s`, v)
+	}
+	for _, v := range obj.Diagnostics {
+		var e *msgpack.ExtError
+		if errors.As(v, &e) {
+			b, _ := json.Marshal(e.Err.Error())
+			ret += fmt.Sprintf(`, "ext_error":%s`, b)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// TokenKind represents the kind of Token.
+type TokenKind int
+
+// Kinds of Token.
+const (
+	Scalar     TokenKind = iota /* object other than array and map */
+	ArrayStart                  /* header of array family. its elements follow */
+	MapStart                    /* header of map family. its keys and values follow alternately */
+	End                         /* end of array or map */
+)
+
+// String implements Stringer interface.
+func (k TokenKind) String() string {
+	switch k {
+	case Scalar:
+		return "scalar"
+	case ArrayStart:
+		return "array start"
+	case MapStart:
+		return "map start"
+	case End:
+		return "end"
+	}
+	return "unknown"
+}
+
+// tokenKind returns the kind of token which starts with obj.
+func tokenKind(obj *MPObject) TokenKind {
+	switch {
+	case obj == nil:
+		return Scalar
+	case IsArray(obj.FirstByte):
+		return ArrayStart
+	case IsMap(obj.FirstByte):
+		return MapStart
+	}
+	return Scalar
+}
+
+// Token represents an element of MessagePack stream.
+// For End, the fields other than Kind describe the container which ends.
+type Token struct {
+	Kind      TokenKind
+	FirstByte byte
+	Format    string      /* format name. e.g. "fixarray", "str 8" and "timestamp 64" */
+	ExtType   int8        /* for ext family */
+	Length    uint32      /* for map, array, str, bin and ext family */
+	Value     interface{} /* typed value of Scalar. see MPObject.Value */
+	Offset    int64       /* position of FirstByte in the input */
+	Size      int64       /* encoded size. for ArrayStart and MapStart, size of the header */
+}
+
+func newToken(kind TokenKind, obj *MPObject) Token {
+	if obj == nil {
+		return Token{Kind: kind}
+	}
+	t := Token{
+		Kind:      kind,
+		FirstByte: obj.FirstByte,
+		Format:    obj.FormatName,
+		ExtType:   obj.ExtType,
+		Length:    obj.Length,
+		Value:     obj.Value,
+		Offset:    obj.Offset,
+		Size:      obj.Size,
+	}
+	if kind == ArrayStart || kind == MapStart {
+		t.Size = int64(obj.HeaderSize)
+	}
+	return t
+}
+
+// Tokenizer reads MessagePack objects from an input stream token by token.
+// It does not build MPObject trees, so the memory usage does not depend on
+// the number of elements. Decoder uses the same tokens to build MPObject.
+type Tokenizer struct {
+	d    *Decoder
+	last TokenKind
+	err  error
+}
+
+// NewTokenizer returns a new tokenizer that reads from r.
+// The options are the same as NewDecoder.
+func NewTokenizer(r io.Reader, opts ...DecoderOption) *Tokenizer {
+	return &Tokenizer{d: NewDecoder(r, opts...), last: Scalar}
+}
+
+// InputOffset returns the number of bytes consumed by the tokenizer.
+func (t *Tokenizer) InputOffset() int64 {
+	return t.d.InputOffset()
+}
+
+// Depth returns the number of containers which are not closed by End yet.
+func (t *Tokenizer) Depth() int {
+	return len(t.d.path)
+}
+
+// Next returns the next token.
+// Top-level objects follow one after another until Next returns io.EOF.
+//
+// If the input is broken, Next returns the token read so far and the error,
+// which is *DecodeError as Decoder.Decode returns.
+// After an error, Next keeps returning the same error.
+func (t *Tokenizer) Next() (Token, error) {
+	if t.err != nil {
+		return Token{}, t.err
+	}
+	kind, obj, err := t.read()
+	t.last = kind
+	return newToken(kind, obj), err
+}
+
+// Skip jumps over the rest of the container started by the last token.
+// The elements are not decoded into values and their payloads are discarded
+// without being read into memory, so they are not validated as UTF-8 either.
+// The next token is the one following End of the container.
+// If the last token is neither ArrayStart nor MapStart, Skip does nothing.
+func (t *Tokenizer) Skip() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.last != ArrayStart && t.last != MapStart {
+		return nil
+	}
+	depth := len(t.d.path)
+	t.d.discard = true
+	defer func() {
+		t.d.discard = false
+	}()
+	for len(t.d.path) >= depth {
+		kind, _, err := t.read()
+		if err != nil {
+			return err
+		}
+		t.last = kind
+	}
+	return nil
+}
+
+// read reads a token and keeps the error.
+func (t *Tokenizer) read() (TokenKind, *MPObject, error) {
+	/* each token has its own buffer since the value of bin refers to it */
+	t.d.raw = nil
+	kind, obj, err := t.d.readToken()
+	if err != nil {
+		t.err = err
+	}
+	return kind, obj, err
+}
+
+// errDiscarded means the payload is skipped by Tokenizer.Skip.
+var errDiscarded = errors.New("payload is discarded")
+
+// skip discards n bytes of obj. It returns errDiscarded if n bytes are skipped.
+func (d *Decoder) skip(obj *MPObject, n int) error {
+	if err := d.checkBytes(obj, d.r.n, n); err != nil {
+		return err
+	}
+	skipped, err := io.CopyN(ioutil.Discard, d.r, int64(n))
+	if err == io.EOF {
+		return d.newError(ErrTruncated, obj, n, int(skipped), io.ErrUnexpectedEOF)
+	} else if err != nil {
+		return d.newError(nil, obj, n, int(skipped), err)
+	}
+	return errDiscarded
+}
@@ -0,0 +1,207 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestTokenizer(t *testing.T) {
+	/* {"a":[1,"xy"],"b":{}} 0xc0 */
+	input := []byte{0x82, 0xa1, 0x61, 0x92, 0x01, 0xa2, 0x78, 0x79, 0xa1, 0x62, 0x80, 0xc0}
+	expected := []Token{
+		{Kind: MapStart, FirstByte: 0x82, Format: "fixmap", Length: 2, Offset: 0, Size: 1},
+		{Kind: Scalar, FirstByte: 0xa1, Format: "fixstr", Length: 1, Value: "a", Offset: 1, Size: 2},
+		{Kind: ArrayStart, FirstByte: 0x92, Format: "fixarray", Length: 2, Offset: 3, Size: 1},
+		{Kind: Scalar, FirstByte: 0x01, Format: "positive fixint", Value: int64(1), Offset: 4, Size: 1},
+		{Kind: Scalar, FirstByte: 0xa2, Format: "fixstr", Length: 2, Value: "xy", Offset: 5, Size: 3},
+		{Kind: End, FirstByte: 0x92, Format: "fixarray", Length: 2, Offset: 3, Size: 5},
+		{Kind: Scalar, FirstByte: 0xa1, Format: "fixstr", Length: 1, Value: "b", Offset: 8, Size: 2},
+		{Kind: MapStart, FirstByte: 0x80, Format: "fixmap", Offset: 10, Size: 1},
+		{Kind: End, FirstByte: 0x80, Format: "fixmap", Offset: 10, Size: 1},
+		{Kind: End, FirstByte: 0x82, Format: "fixmap", Length: 2, Offset: 0, Size: 11},
+		{Kind: Scalar, FirstByte: 0xc0, Format: "nil", Offset: 11, Size: 1},
+	}
+
+	tk := NewTokenizer(bytes.NewReader(input))
+	for i, e := range expected {
+		tok, err := tk.Next()
+		if err != nil {
+			t.Fatalf("%d: err=%v", i, err)
+		}
+		if !reflect.DeepEqual(tok, e) {
+			t.Errorf("%d: mismatch.\n given: %+v\n expected: %+v", i, tok, e)
+		}
+	}
+	if _, err := tk.Next(); err != io.EOF {
+		t.Errorf("not EOF. err=%v", err)
+	}
+	if tk.InputOffset() != int64(len(input)) {
+		t.Errorf("offset mismatch. given=%d", tk.InputOffset())
+	}
+}
+
+func TestTokenizerSkip(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		skipAt   int /* Skip is called after this number of tokens */
+		expected []string
+	}
+
+	/* [[1,"ab",{"k":[2]}],3] */
+	nested := []byte{0x92, 0x93, 0x01, 0xa2, 0x61, 0x62, 0x81, 0xa1, 0x6b, 0x91, 0x02, 0x03}
+	/* ["\xff"] 0x04 : invalid UTF-8 is not validated while skipping */
+	invalid := []byte{0x91, 0xa1, 0xff, 0x04}
+
+	cases := []testcase{
+		{"skip top", nested, 1, []string{}},
+		{"skip inner", nested, 2, []string{"positive fixint 3", "end fixarray"}},
+		{"skip map", nested, 5, []string{"end fixarray", "positive fixint 3", "end fixarray"}},
+		{"skip scalar", nested, 3, []string{"fixstr ab", "fixmap"}},
+		{"skip invalid", invalid, 1, []string{"positive fixint 4"}},
+	}
+
+	for _, v := range cases {
+		tk := NewTokenizer(bytes.NewReader(v.bytes), Strict())
+		for i := 0; i < v.skipAt; i++ {
+			if _, err := tk.Next(); err != nil {
+				t.Fatalf("%s: err=%v", v.casename, err)
+			}
+		}
+		if err := tk.Skip(); err != nil {
+			t.Errorf("%s: Skip error=%v", v.casename, err)
+			continue
+		}
+		for i, e := range v.expected {
+			tok, err := tk.Next()
+			if err != nil {
+				t.Errorf("%s: %d: err=%v", v.casename, i, err)
+				break
+			}
+			s := tok.Format
+			switch tok.Kind {
+			case End:
+				s = "end " + tok.Format
+			case Scalar:
+				s = fmt.Sprintf("%s %v", tok.Format, tok.Value)
+			}
+			if s != e {
+				t.Errorf("%s: %d: given %q expected %q", v.casename, i, s, e)
+			}
+		}
+	}
+}
+
+func TestTokenizerError(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		skip     bool
+		kind     error
+		path     string
+	}
+
+	cases := []testcase{
+		{"truncated array", []byte{0x92, 0x01}, false, ErrTruncated, "$[1]"},
+		{"truncated str", []byte{0x91, 0xa3, 0x41}, false, ErrTruncated, "$[0]"},
+		{"never used", []byte{0x81, 0xa1, 0x41, 0xc1}, false, ErrNeverUsed, "$.A"},
+		{"skip truncated", []byte{0x91, 0xa3, 0x41}, true, ErrTruncated, "$[0]"},
+		{"skip never used", []byte{0x92, 0x01, 0xc1}, true, ErrNeverUsed, "$[1]"},
+	}
+
+	for _, v := range cases {
+		tk := NewTokenizer(bytes.NewReader(v.bytes), Strict())
+		var err error
+		if v.skip {
+			if _, err = tk.Next(); err == nil {
+				err = tk.Skip()
+			}
+		} else {
+			for err == nil {
+				_, err = tk.Next()
+			}
+		}
+		if !errors.Is(err, v.kind) {
+			t.Errorf("%s: kind mismatch. err=%v", v.casename, err)
+			continue
+		}
+		var e *DecodeError
+		if errors.As(err, &e) && e.Path != v.path {
+			t.Errorf("%s: path mismatch. given=%s expected=%s", v.casename, e.Path, v.path)
+		}
+		if _, again := tk.Next(); again != err {
+			t.Errorf("%s: error is not kept. err=%v", v.casename, again)
+		}
+	}
+}
+
+/* tokens converts obj into the tokens which Tokenizer returns. */
+func tokens(obj *MPObject) []Token {
+	kind := tokenKind(obj)
+	ret := []Token{newToken(kind, obj)}
+	if kind == Scalar {
+		return ret
+	}
+	for _, v := range obj.Child {
+		ret = append(ret, tokens(v)...)
+	}
+	return append(ret, newToken(End, obj))
+}
+
+func TestTokenizerDecode(t *testing.T) {
+	inputs := [][]byte{
+		{0x82, 0xa1, 0x61, 0x92, 0x01, 0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0xa1, 0x62, 0x80},
+		{0xdc, 0x00, 0x02, 0xc4, 0x01, 0xff, 0xd6, 0xff, 0x00, 0x00, 0x00, 0x01},
+		{0x81, 0x91, 0x01, 0xde, 0x00, 0x01, 0xc3, 0xc0},
+		{0x90, 0x80, 0xa0, 0xd0, 0x80},
+	}
+
+	for i, input := range inputs {
+		dec := NewDecoder(bytes.NewReader(input))
+		expected := []Token{}
+		for {
+			obj, err := dec.Decode()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%d: err=%v", i, err)
+			}
+			expected = append(expected, tokens(obj)...)
+		}
+
+		tk := NewTokenizer(bytes.NewReader(input))
+		given := []Token{}
+		for {
+			tok, err := tk.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%d: err=%v", i, err)
+			}
+			given = append(given, tok)
+		}
+		if !reflect.DeepEqual(given, expected) {
+			t.Errorf("%d: mismatch.\n given: %+v\n expected: %+v", i, given, expected)
+		}
+	}
+}
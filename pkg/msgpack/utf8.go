@@ -0,0 +1,65 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// UTF8Error describes the first invalid byte of str object which is not valid UTF-8.
+type UTF8Error struct {
+	Offset int64 /* position of the invalid byte in the input */
+	Index  int   /* position of the invalid byte in the payload */
+}
+
+// Error implements error interface.
+func (e *UTF8Error) Error() string {
+	return fmt.Sprintf("invalid UTF-8 at offset %d (payload index %d)", e.Offset, e.Index)
+}
+
+// invalidUTF8Index returns the index of the first byte of s which is not valid UTF-8.
+// It returns -1 if s is valid UTF-8.
+func invalidUTF8Index(s string) int {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
+
+// setStr sets the payload of str family and checks whether it is valid UTF-8.
+// In strict mode, invalid UTF-8 is reported as DecodeError.
+func (obj *MPObject) setStr(d *Decoder, str []byte) error {
+	obj.SetValue(string(str))
+	obj.InvalidUTF8 = nil
+
+	i := invalidUTF8Index(obj.DataStr)
+	if i < 0 {
+		return nil
+	}
+	obj.InvalidUTF8 = &UTF8Error{Offset: obj.Offset + int64(obj.HeaderSize) + int64(i), Index: i}
+	if d.strict {
+		e := d.newError(ErrInvalidUTF8, obj, 0, 0, obj.InvalidUTF8)
+		e.Offset = obj.InvalidUTF8.Offset
+		return e
+	}
+	return nil
+}
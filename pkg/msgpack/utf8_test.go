@@ -0,0 +1,92 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestInvalidUTF8(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		invalid  bool
+		offset   int64
+		index    int
+	}
+
+	cases := []testcase{
+		{"fixstr", []byte{0xa2, 0x41, 0x42}, false, 0, 0},
+		{"fixstr multibyte", []byte{0xa3, 0xe3, 0x81, 0x93}, false, 0, 0},
+		{"empty", []byte{0xa0}, false, 0, 0},
+		{"fixstr invalid", []byte{0xa3, 0x41, 0xff, 0x42}, true, 2, 1},
+		{"fixstr truncated rune", []byte{0xa3, 0x41, 0xe3, 0x81}, true, 2, 1},
+		{"str8 invalid", []byte{0xd9, 0x02, 0xc0, 0x80}, true, 2, 0},
+		{"str16 invalid", []byte{0xda, 0x00, 0x02, 0x41, 0x80}, true, 4, 1},
+		{"str32 invalid", []byte{0xdb, 0x00, 0x00, 0x00, 0x01, 0xfe}, true, 5, 0},
+		{"surrogate", []byte{0xa3, 0xed, 0xa0, 0x80}, true, 1, 0},
+		{"in array", []byte{0x92, 0x01, 0xa1, 0xff}, true, 3, 0},
+	}
+
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: err=%v", v.casename, err)
+			continue
+		}
+		if IsArray(obj.FirstByte) {
+			obj = obj.Child[len(obj.Child)-1]
+		}
+		if !v.invalid {
+			if obj.InvalidUTF8 != nil {
+				t.Errorf("%s: InvalidUTF8 is set. %v", v.casename, obj.InvalidUTF8)
+			}
+			continue
+		}
+		if obj.InvalidUTF8 == nil {
+			t.Errorf("%s: InvalidUTF8 is not set", v.casename)
+			continue
+		}
+		if obj.InvalidUTF8.Offset != v.offset || obj.InvalidUTF8.Index != v.index {
+			t.Errorf("%s: mismatch. given: %v expected: offset=%d index=%d", v.casename, obj.InvalidUTF8, v.offset, v.index)
+		}
+
+		/* strict mode */
+		_, err = NewDecoder(bytes.NewReader(v.bytes), Strict()).Decode()
+		if !errors.Is(err, ErrInvalidUTF8) {
+			t.Errorf("%s: strict: ErrInvalidUTF8 is not returned. err=%v", v.casename, err)
+			continue
+		}
+		var e *DecodeError
+		if !errors.As(err, &e) || e.Offset != v.offset {
+			t.Errorf("%s: strict: offset mismatch. err=%v", v.casename, err)
+		}
+	}
+}
+
+func TestStrictValid(t *testing.T) {
+	for _, v := range decodeTestCases() {
+		obj, err := NewDecoder(bytes.NewReader(v.bytes), Strict()).Decode()
+		if errors.Is(err, ErrInvalidUTF8) {
+			t.Errorf("%s: err=%v", v.casename, err)
+		} else if obj != nil && obj.InvalidUTF8 != nil {
+			t.Errorf("%s: InvalidUTF8 is set. %v", v.casename, obj.InvalidUTF8)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ext represents the raw payload of ext family.
+// It is used as MPObject.Value if the ext type is not decoded to other type.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// SetValue sets v as Value and updates DataStr to keep them in agreement.
+// Use it to edit a decoded value, since renderers output DataStr.
+func (obj *MPObject) SetValue(v interface{}) {
+	obj.Value = v
+	obj.DataStr = formatValue(v)
+}
+
+// formatValue converts a value into DataStr.
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	case int64, uint64:
+		return fmt.Sprintf("%d", val)
+	case float32:
+		/* the shortest representation which is parsed into the same value */
+		return strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case string:
+		return val
+	case []byte:
+		return fmt.Sprintf("0x%x", val)
+	case time.Time:
+		return fmt.Sprintf("%v", val)
+	case Ext:
+		return fmt.Sprintf("0x%x", val.Data)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Layouts of TimeFormat which are not layouts of time.Time.Format.
+const (
+	TimeUnix     = "unix"     /* seconds since the Unix epoch. e.g. 1557792000.5 */
+	TimeUnixNano = "unixnano" /* nanoseconds since the Unix epoch. e.g. 1557792000500000000 */
+)
+
+// TimeFormat is a policy to show time.Time value of timestamp ext and Fluentd event time ext.
+// The zero value shows time like time.Time.String in local time.
+type TimeFormat struct {
+	Location *time.Location /* time zone. nil means local time */
+	Layout   string         /* layout of time.Time.Format, TimeUnix or TimeUnixNano. empty means time.Time.String */
+}
+
+// Format returns the string representation of t.
+func (f TimeFormat) Format(t time.Time) string {
+	if f.Location != nil {
+		t = t.In(f.Location)
+	}
+	switch f.Layout {
+	case "":
+		return t.String()
+	case TimeUnix:
+		return formatUnix(t)
+	case TimeUnixNano:
+		/* time.Time.UnixNano overflows out of years 1678 to 2262 */
+		n := new(big.Int).Mul(big.NewInt(t.Unix()), big.NewInt(1e9))
+		return n.Add(n, big.NewInt(int64(t.Nanosecond()))).String()
+	}
+	return t.Format(f.Layout)
+}
+
+// IsNumber reports whether Format returns a number.
+func (f TimeFormat) IsNumber() bool {
+	return f.Layout == TimeUnix || f.Layout == TimeUnixNano
+}
+
+// formatUnix returns seconds since the Unix epoch with fraction if t has nanoseconds.
+func formatUnix(t time.Time) string {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	sign := ""
+	if sec < 0 {
+		/* e.g. -1 second and 5 nanoseconds is -0.999999995 */
+		sign = "-"
+		sec, nsec = -(sec + 1), 1e9-nsec
+	}
+	return sign + strconv.FormatInt(sec, 10) + "." + strings.TrimRight(fmt.Sprintf("%09d", nsec), "0")
+}
+
+// WithTimeFormat makes the decoder set DataStr of time.Time value by f.
+func WithTimeFormat(f TimeFormat) DecoderOption {
+	return func(d *Decoder) {
+		d.times = f
+	}
+}
+
+// formatTime updates DataStr of time.Time value by the time format of the decoder.
+func (d *Decoder) formatTime(obj *MPObject) {
+	if t, ok := obj.Value.(time.Time); ok {
+		obj.DataStr = d.times.Format(t)
+	}
+}
+
+// IsNil reports whether obj is nil format.
+func (obj *MPObject) IsNil() bool {
+	return obj.FirstByte == NilFormat
+}
+
+// Bool returns the value of true and false format.
+// ok is false if obj is not bool.
+func (obj *MPObject) Bool() (v bool, ok bool) {
+	v, ok = obj.Value.(bool)
+	return v, ok
+}
+
+// Int returns the value as int64.
+// ok is false if obj is not integer or the value overflows int64.
+func (obj *MPObject) Int() (v int64, ok bool) {
+	switch val := obj.Value.(type) {
+	case int64:
+		return val, true
+	case uint64:
+		if val > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(val), true
+	}
+	return 0, false
+}
+
+// Uint returns the value as uint64.
+// ok is false if obj is not integer or the value is negative.
+func (obj *MPObject) Uint() (v uint64, ok bool) {
+	switch val := obj.Value.(type) {
+	case uint64:
+		return val, true
+	case int64:
+		if val < 0 {
+			return 0, false
+		}
+		return uint64(val), true
+	}
+	return 0, false
+}
+
+// Float returns the value of float family as float64.
+// ok is false if obj is not float.
+func (obj *MPObject) Float() (v float64, ok bool) {
+	switch val := obj.Value.(type) {
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Str returns the value of str family.
+// ok is false if obj is not str.
+func (obj *MPObject) Str() (v string, ok bool) {
+	v, ok = obj.Value.(string)
+	return v, ok
+}
+
+// Bytes returns the payload of bin family or ext family which is not decoded.
+// ok is false if obj is neither bin nor ext.
+func (obj *MPObject) Bytes() (v []byte, ok bool) {
+	switch val := obj.Value.(type) {
+	case []byte:
+		return val, true
+	case Ext:
+		return val.Data, true
+	}
+	return nil, false
+}
+
+// Time returns the value of timestamp ext.
+// ok is false if obj is not decoded as time.
+func (obj *MPObject) Time() (v time.Time, ok bool) {
+	v, ok = obj.Value.(time.Time)
+	return v, ok
+}
@@ -0,0 +1,242 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		value    interface{}
+	}
+
+	cases := []testcase{
+		{"p fixint", []byte{0x01}, int64(1)},
+		{"n fixint", []byte{0xff}, int64(-1)},
+		{"nil", []byte{0xc0}, nil},
+		{"true", []byte{0xc3}, true},
+		{"false", []byte{0xc2}, false},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, float32(1.5)},
+		{"float64", []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, float64(1.5)},
+		{"uint8", []byte{0xcc, 0xff}, uint64(255)},
+		{"uint16", []byte{0xcd, 0xff, 0x00}, uint64(65280)},
+		{"uint32", []byte{0xce, 0xff, 0x00, 0xff, 0x00}, uint64(4278255360)},
+		{"uint64", []byte{0xcf, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, uint64(18374966859414961920)},
+		{"int8", []byte{0xd0, 0xff}, int64(-1)},
+		{"int16", []byte{0xd1, 0xff, 0x00}, int64(-256)},
+		{"int32", []byte{0xd2, 0xff, 0x00, 0xff, 0x00}, int64(-16711936)},
+		{"int64", []byte{0xd3, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00}, int64(-71777214294589696)},
+		{"fixstr", []byte{0xa2, 0x41, 0x42}, "AB"},
+		{"timestamp 32", []byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, time.Unix(1, 0)},
+		{"timestamp 96 broken", []byte{0xc7, 0x01, 0xff, 0x00}, Ext{Type: -1, Data: []byte{0x00}}},
+	}
+
+	for _, v := range cases {
+		ret, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		switch expect := v.value.(type) {
+		case time.Time:
+			tm, ok := ret.Value.(time.Time)
+			if !ok || !tm.Equal(expect) {
+				t.Errorf("%s: Value mismatch: %v, expect %v", v.casename, ret.Value, v.value)
+			}
+		case Ext:
+			ext, ok := ret.Value.(Ext)
+			if !ok || ext.Type != expect.Type || !bytes.Equal(ext.Data, expect.Data) {
+				t.Errorf("%s: Value mismatch: %v, expect %v", v.casename, ret.Value, v.value)
+			}
+		default:
+			if ret.Value != v.value {
+				t.Errorf("%s: Value mismatch: %v(%T), expect %v(%T)", v.casename, ret.Value, ret.Value, v.value, v.value)
+			}
+		}
+		if ret.DataStr != formatValue(ret.Value) {
+			t.Errorf("%s: DataStr %s does not agree with Value %v", v.casename, ret.DataStr, ret.Value)
+		}
+	}
+}
+
+func TestFloatDataStr(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		expected string
+	}
+
+	cases := []testcase{
+		{"float32 1.5", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, "1.5"},
+		{"float32 0.1", []byte{0xca, 0x3d, 0xcc, 0xcc, 0xcd}, "0.1"},
+		{"float32 max", []byte{0xca, 0x7f, 0x7f, 0xff, 0xff}, "3.4028235e+38"},
+		{"float64 0.1", []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, "0.1"},
+		{"float64 1e-9", []byte{0xcb, 0x3e, 0x11, 0x2e, 0x0b, 0xe8, 0x26, 0xd6, 0x95}, "1e-09"},
+		{"float64 pi", []byte{0xcb, 0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}, "3.141592653589793"},
+		{"float64 -0", []byte{0xcb, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "-0"},
+		{"float32 NaN", []byte{0xca, 0x7f, 0xc0, 0x00, 0x00}, "NaN"},
+		{"float64 +Inf", []byte{0xcb, 0x7f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "+Inf"},
+		{"float64 -Inf", []byte{0xcb, 0xff, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "-Inf"},
+	}
+
+	for _, v := range cases {
+		ret, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		if ret.DataStr != v.expected {
+			t.Errorf("%s: DataStr mismatch: %s, expect %s", v.casename, ret.DataStr, v.expected)
+		}
+	}
+}
+
+func TestValueAccessor(t *testing.T) {
+	obj := &MPObject{Value: int64(-1)}
+	if v, ok := obj.Int(); !ok || v != -1 {
+		t.Errorf("Int error: %d %t", v, ok)
+	}
+	if _, ok := obj.Uint(); ok {
+		t.Errorf("Uint should fail for negative value")
+	}
+
+	obj = &MPObject{Value: uint64(1 << 63)}
+	if _, ok := obj.Int(); ok {
+		t.Errorf("Int should fail for overflow value")
+	}
+	if v, ok := obj.Uint(); !ok || v != 1<<63 {
+		t.Errorf("Uint error: %d %t", v, ok)
+	}
+
+	obj = &MPObject{Value: float32(0.5)}
+	if v, ok := obj.Float(); !ok || v != 0.5 {
+		t.Errorf("Float error: %f %t", v, ok)
+	}
+	if _, ok := obj.Int(); ok {
+		t.Errorf("Int should fail for float value")
+	}
+
+	obj = &MPObject{Value: Ext{Type: 1, Data: []byte{0x01}}}
+	if v, ok := obj.Bytes(); !ok || !bytes.Equal(v, []byte{0x01}) {
+		t.Errorf("Bytes error: %x %t", v, ok)
+	}
+
+	obj = &MPObject{Value: "str"}
+	if v, ok := obj.Str(); !ok || v != "str" {
+		t.Errorf("Str error: %s %t", v, ok)
+	}
+	if _, ok := obj.Bytes(); ok {
+		t.Errorf("Bytes should fail for str value")
+	}
+
+	obj = &MPObject{FirstByte: NilFormat}
+	if !obj.IsNil() {
+		t.Errorf("IsNil error")
+	}
+	if _, ok := obj.Time(); ok {
+		t.Errorf("Time should fail for nil value")
+	}
+
+	obj = &MPObject{Value: true}
+	if v, ok := obj.Bool(); !ok || !v {
+		t.Errorf("Bool error: %t %t", v, ok)
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	type testcase struct {
+		casename string
+		value    interface{}
+		bytes    []byte /* encoded value whose DataStr is expected */
+	}
+
+	cases := []testcase{
+		{"int", int64(-2), []byte{0xfe}},
+		{"bool", false, []byte{0xc2}},
+		{"nil", nil, []byte{0xc0}},
+		{"str", "AB", []byte{0xa2, 0x41, 0x42}},
+		{"float64", float64(1.5), []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	for _, v := range cases {
+		obj, err := Decode(bytes.NewBuffer([]byte{0xc3}))
+		if err != nil {
+			t.Fatalf("Decode error %s", err)
+		}
+		obj.SetValue(v.value)
+		expect, err := Decode(bytes.NewBuffer(v.bytes))
+		if err != nil {
+			t.Errorf("%s: Decode error %s", v.casename, err)
+			continue
+		}
+		if obj.Value != expect.Value || obj.DataStr != expect.DataStr {
+			t.Errorf("%s: mismatch. given: %v %s, expect: %v %s", v.casename, obj.Value, obj.DataStr, expect.Value, expect.DataStr)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	type testcase struct {
+		casename string
+		format   TimeFormat
+		time     time.Time
+		expected string
+	}
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tm := time.Unix(1557792000, 500000000)
+	cases := []testcase{
+		{"zero", TimeFormat{}, tm, tm.String()},
+		{"utc", TimeFormat{Location: time.UTC}, tm, "2019-05-14 00:00:00.5 +0000 UTC"},
+		{"rfc3339", TimeFormat{Location: time.UTC, Layout: time.RFC3339}, tm, "2019-05-14T00:00:00Z"},
+		{"rfc3339nano", TimeFormat{Location: tokyo, Layout: time.RFC3339Nano}, tm, "2019-05-14T09:00:00.5+09:00"},
+		{"custom", TimeFormat{Location: tokyo, Layout: "2006/01/02 15:04 MST"}, tm, "2019/05/14 09:00 JST"},
+		{"unix", TimeFormat{Layout: TimeUnix}, tm, "1557792000.5"},
+		{"unix sec", TimeFormat{Layout: TimeUnix}, time.Unix(1, 0), "1"},
+		{"unix negative", TimeFormat{Layout: TimeUnix}, time.Unix(-1, 5), "-0.999999995"},
+		{"unixnano", TimeFormat{Layout: TimeUnixNano}, tm, "1557792000500000000"},
+		{"unixnano large", TimeFormat{Layout: TimeUnixNano}, time.Unix(1<<40, 1), "1099511627776000000001"},
+	}
+
+	for _, v := range cases {
+		if ret := v.format.Format(v.time); ret != v.expected {
+			t.Errorf("%s: mismatch. given: %s expected: %s", v.casename, ret, v.expected)
+		}
+	}
+}
+
+func TestDecodeWithTimeFormat(t *testing.T) {
+	r := NewExtRegistry()
+	r.RegisterFluentdEventTime()
+	/* [timestamp 32, event time] */
+	b := []byte{0x92, 0xd6, 0xff, 0x5c, 0xda, 0x05, 0x00, 0xd7, 0x00, 0x5c, 0xda, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01}
+	obj, err := NewDecoder(bytes.NewReader(b), WithExtRegistry(r), WithTimeFormat(TimeFormat{Location: time.UTC, Layout: time.RFC3339Nano})).Decode()
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	expected := []string{"2019-05-14T00:00:00Z", "2019-05-14T00:00:00.000000001Z"}
+	for i, v := range obj.Child {
+		if v.DataStr != expected[i] {
+			t.Errorf("%d: mismatch. given: %s expected: %s", i, v.DataStr, expected[i])
+		}
+	}
+}
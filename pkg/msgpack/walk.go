@@ -0,0 +1,173 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PathElem represents a step from a container to its element.
+type PathElem struct {
+	Index int       /* index of array element, or index of key-value pair of map */
+	Key   *MPObject /* key of map value. nil for array element */
+}
+
+// String implements Stringer interface. e.g. [0], .tag and ["a b"]
+func (e PathElem) String() string {
+	if e.Key == nil {
+		return fmt.Sprintf("[%d]", e.Index)
+	}
+	return formatKey(e.Key)
+}
+
+// Path represents the location of an object from the top-level object.
+type Path []PathElem
+
+// String implements Stringer interface. e.g. $.a[0]["k"]
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, v := range p {
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// Copy returns a copy of p which is not modified by Walk.
+func (p Path) Copy() Path {
+	return append(Path{}, p...)
+}
+
+// WalkFunc is the type of the function called by Walk for each object.
+// If the function returns SkipChildren, Walk does not visit the elements of obj.
+// If the function returns Stop, Walk stops and returns nil.
+// Other errors stop Walk and are returned by Walk.
+type WalkFunc func(path Path, obj *MPObject) error
+
+// Control values of WalkFunc.
+var (
+	SkipChildren = errors.New("skip children")
+	Stop         = errors.New("stop walking")
+)
+
+// Walk visits obj and its elements in depth-first order.
+// The elements of map are values and their keys are available as Key of the last PathElem.
+// Keys are not visited. A key without value of a broken map is also not visited.
+//
+// path is reused by Walk. Use Path.Copy to keep it after fn returns.
+func Walk(obj *MPObject, fn WalkFunc) error {
+	if obj == nil {
+		return nil
+	}
+	type frame struct {
+		obj  *MPObject
+		next int /* index of the next child */
+	}
+
+	path := Path{}
+	stack := []frame{}
+	for {
+		err := fn(path, obj)
+		switch {
+		case err == Stop:
+			return nil
+		case err == SkipChildren:
+		case err != nil:
+			return err
+		case len(obj.Child) > 0:
+			stack = append(stack, frame{obj: obj})
+		}
+
+		/* find the next object */
+		obj = nil
+		for obj == nil && len(stack) > 0 {
+			f := &stack[len(stack)-1]
+			path = path[:len(stack)-1]
+			switch {
+			case IsMap(f.obj.FirstByte) && f.next+1 < len(f.obj.Child):
+				path = append(path, PathElem{Index: f.next / 2, Key: f.obj.Child[f.next]})
+				obj = f.obj.Child[f.next+1]
+				f.next += 2
+			case !IsMap(f.obj.FirstByte) && f.next < len(f.obj.Child):
+				path = append(path, PathElem{Index: f.next})
+				obj = f.obj.Child[f.next]
+				f.next++
+			default:
+				stack = stack[:len(stack)-1]
+			}
+		}
+		if obj == nil {
+			return nil
+		}
+	}
+}
+
+// Visit is an object which Traverse visits.
+type Visit struct {
+	Obj    *MPObject
+	Parent *MPObject /* array or map which has Obj. nil for the top-level object */
+	Index  int       /* index of Obj in Parent.Child. keys of map are even and values are odd */
+	Leave  bool      /* Obj is array or map and all its elements have been visited */
+}
+
+// Traverse calls fn for obj and its descendants in the order of the input. Keys of map are also visited.
+// Array and map are visited twice, before and after their elements, so they can be output with brackets.
+// A key without value of a broken map is not visited.
+//
+// The containers being visited are kept in a stack instead of the call stack,
+// so deeply nested obj can be visited.
+func Traverse(obj *MPObject, fn func(v Visit)) {
+	if obj == nil {
+		return
+	}
+	type frame struct {
+		obj  *MPObject
+		n    int /* number of elements to visit */
+		next int /* index of the next element */
+	}
+
+	stack := []frame{}
+	v := Visit{Obj: obj}
+	for {
+		fn(v)
+		if !v.Leave && (IsArray(v.Obj.FirstByte) || IsMap(v.Obj.FirstByte)) {
+			f := frame{obj: v.Obj, n: len(v.Obj.Child)}
+			if IsMap(v.Obj.FirstByte) {
+				f.n = len(v.Obj.Child) / 2 * 2
+			}
+			stack = append(stack, f)
+		}
+		if len(stack) == 0 {
+			return
+		}
+
+		f := &stack[len(stack)-1]
+		if f.next < f.n {
+			v = Visit{Obj: f.obj.Child[f.next], Parent: f.obj, Index: f.next}
+			f.next++
+			continue
+		}
+		v = Visit{Obj: f.obj, Leave: true}
+		stack = stack[:len(stack)-1]
+		if len(stack) > 0 {
+			p := &stack[len(stack)-1]
+			v.Parent, v.Index = p.obj, p.next-1
+		}
+	}
+}
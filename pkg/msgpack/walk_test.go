@@ -0,0 +1,165 @@
+/*
+   Copyright 2019 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	type testcase struct {
+		casename string
+		control  map[string]error /* WalkFunc returns the error at the path */
+		expected []string
+		err      error
+	}
+
+	/* {"a":[1,{"k":"v"}],"b c":{1:true}} */
+	obj := NewMap([]*MPObject{
+		NewStr("a"), NewArray([]*MPObject{NewInt(1), NewMap([]*MPObject{NewStr("k"), NewStr("v")})}),
+		NewStr("b c"), NewMap([]*MPObject{NewInt(1), NewBool(true)}),
+	})
+	failure := errors.New("failure")
+
+	cases := []testcase{
+		{"all", nil, []string{`$ (fixmap)`, `$.a (fixarray)`, `$.a[0] 1`, `$.a[1] (fixmap)`, `$.a[1].k v`, `$["b c"] (fixmap)`, `$["b c"][1] true`}, nil},
+		{"skip", map[string]error{"$.a": SkipChildren}, []string{`$ (fixmap)`, `$.a (fixarray)`, `$["b c"] (fixmap)`, `$["b c"][1] true`}, nil},
+		{"skip top", map[string]error{"$": SkipChildren}, []string{`$ (fixmap)`}, nil},
+		{"stop", map[string]error{"$.a[1]": Stop}, []string{`$ (fixmap)`, `$.a (fixarray)`, `$.a[0] 1`, `$.a[1] (fixmap)`}, nil},
+		{"error", map[string]error{"$.a[0]": failure}, []string{`$ (fixmap)`, `$.a (fixarray)`, `$.a[0] 1`}, failure},
+	}
+
+	for _, v := range cases {
+		given := []string{}
+		err := Walk(obj, func(path Path, obj *MPObject) error {
+			given = append(given, fmt.Sprintf("%s %s", path, obj.DataStr))
+			return v.control[path.String()]
+		})
+		if err != v.err {
+			t.Errorf("%s: err=%v expected=%v", v.casename, err, v.err)
+		}
+		if !reflect.DeepEqual(given, v.expected) {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", v.casename, given, v.expected)
+		}
+	}
+}
+
+func TestWalkPath(t *testing.T) {
+	/* [{"k":[0]}] */
+	obj := NewArray([]*MPObject{NewMap([]*MPObject{NewStr("k"), NewArray([]*MPObject{NewInt(0)})})})
+
+	paths := []Path{}
+	err := Walk(obj, func(path Path, obj *MPObject) error {
+		paths = append(paths, path.Copy())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if len(paths) != 4 {
+		t.Fatalf("length mismatch. given=%d", len(paths))
+	}
+	last := paths[3]
+	if last.String() != "$[0].k[0]" {
+		t.Errorf("path mismatch. given=%s", last)
+	}
+	if len(last) != 3 || last[0].Key != nil || last[0].Index != 0 || last[2].Index != 0 {
+		t.Errorf("elements mismatch. given=%+v", last)
+	}
+	if s, _ := last[1].Key.Str(); s != "k" || last[1].Index != 0 {
+		t.Errorf("key mismatch. given=%+v", last[1])
+	}
+}
+
+func TestWalkBroken(t *testing.T) {
+	/* {"a":1,"b": is truncated */
+	obj, err := Decode(bytes.NewBuffer([]byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62}))
+	if err == nil {
+		t.Fatalf("no error")
+	}
+	given := []string{}
+	Walk(obj, func(path Path, obj *MPObject) error {
+		given = append(given, path.String())
+		return nil
+	})
+	if !reflect.DeepEqual(given, []string{"$", "$.a"}) {
+		t.Errorf("mismatch. given=%q", given)
+	}
+}
+
+func TestTraverse(t *testing.T) {
+	type testcase struct {
+		casename string
+		bytes    []byte
+		expected []string /* DataStr, index and "leave" of each visit */
+	}
+
+	cases := []testcase{
+		{"scalar", []byte{0x01}, []string{"1"}},
+		{"empty array", []byte{0x90}, []string{"(fixarray)", "leave (fixarray)"}},
+		/* {"a":[1,2]} */
+		{"nested", []byte{0x81, 0xa1, 0x61, 0x92, 0x01, 0x02},
+			[]string{"(fixmap)", "a 0", "(fixarray) 1", "1 0", "2 1", "leave (fixarray) 1", "leave (fixmap)"}},
+		/* {[1]:2} */
+		{"array key", []byte{0x81, 0x91, 0x01, 0x02},
+			[]string{"(fixmap)", "(fixarray) 0", "1 0", "leave (fixarray) 0", "2 1", "leave (fixmap)"}},
+		/* {"a":1,"b": is truncated */
+		{"broken map", []byte{0x82, 0xa1, 0x61, 0x01, 0xa1, 0x62}, []string{"(fixmap)", "a 0", "1 1", "leave (fixmap)"}},
+	}
+
+	for _, v := range cases {
+		obj, _ := Decode(bytes.NewBuffer(v.bytes))
+		given := []string{}
+		Traverse(obj, func(visit Visit) {
+			s := visit.Obj.DataStr
+			if visit.Leave {
+				s = "leave " + s
+			}
+			if visit.Parent != nil {
+				s = fmt.Sprintf("%s %d", s, visit.Index)
+			}
+			given = append(given, s)
+		})
+		if !reflect.DeepEqual(given, v.expected) {
+			t.Errorf("%s: mismatch.\n given: %q\n expected: %q", v.casename, given, v.expected)
+		}
+	}
+}
+
+func TestTraverseDeepNesting(t *testing.T) {
+	/* [[[...[1]...]]] */
+	depth := 100000
+	obj, err := Decode(bytes.NewBuffer(append(bytes.Repeat([]byte{0x91}, depth), 0x01)))
+	if err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	enter, leave := 0, 0
+	Traverse(obj, func(v Visit) {
+		if v.Leave {
+			leave++
+		} else {
+			enter++
+		}
+	})
+	if enter != depth+1 || leave != depth {
+		t.Errorf("mismatch. enter=%d leave=%d", enter, leave)
+	}
+}